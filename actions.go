@@ -0,0 +1,201 @@
+package kahva
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// Torrent actions without parameters mapped to their rTorrent methods
+var torrentActions = map[string]string{
+	"start":  "d.start",
+	"stop":   "d.stop",
+	"pause":  "d.pause",
+	"resume": "d.resume",
+	"hash":   "d.check_hash",
+	"erase":  "d.erase",
+}
+
+// Returns the calls performing an action on each of the hashes
+func actionCalls(req ActionRequest, hashes []string, delimiter string) ([]SystemCall, error) {
+	calls := make([]SystemCall, 0, len(hashes))
+	for _, hash := range hashes {
+		if method, ok := torrentActions[req.Action]; ok {
+			calls = append(calls, SystemCall{MethodName: method, Params: []interface{}{hash}})
+			continue
+		}
+
+		switch req.Action {
+		case "priority":
+			if req.Priority == nil || *req.Priority < 0 || *req.Priority > 3 {
+				return nil, fmt.Errorf("%w: priority must be between 0 and 3", ErrInvalidArgument)
+			}
+			calls = append(calls, SystemCall{MethodName: "d.priority.set", Params: []interface{}{hash, *req.Priority}})
+		case "label":
+			if req.Label == nil {
+				return nil, fmt.Errorf("%w: label is required", ErrInvalidArgument)
+			}
+			value, err := formatLabels([]string{*req.Label}, delimiter)
+			if err != nil {
+				return nil, err
+			}
+			calls = append(calls, SystemCall{MethodName: "d.custom1.set", Params: []interface{}{hash, value}})
+		default:
+			return nil, fmt.Errorf("%w: unknown action %q", ErrInvalidArgument, req.Action)
+		}
+	}
+	return calls, nil
+}
+
+// Returns the calls applying an update to a torrent. t is the current torrent, it is
+// closed to change its directory and started again if it was started.
+func updateCalls(hash string, req TorrentUpdateRequest, t Torrent, delimiter string) ([]SystemCall, error) {
+	calls := make([]SystemCall, 0)
+	if req.Priority != nil {
+		if *req.Priority < 0 || *req.Priority > 3 {
+			return nil, fmt.Errorf("%w: priority must be between 0 and 3", ErrInvalidArgument)
+		}
+		calls = append(calls, SystemCall{MethodName: "d.priority.set", Params: []interface{}{hash, *req.Priority}})
+	}
+	if req.Label != nil {
+		value, err := formatLabels([]string{*req.Label}, delimiter)
+		if err != nil {
+			return nil, err
+		}
+		calls = append(calls, SystemCall{MethodName: "d.custom1.set", Params: []interface{}{hash, value}})
+	}
+	if req.Directory != nil {
+		if !strings.HasPrefix(*req.Directory, "/") {
+			return nil, fmt.Errorf("%w: directory must be an absolute path", ErrInvalidArgument)
+		}
+		calls = append(calls,
+			SystemCall{MethodName: "d.stop", Params: []interface{}{hash}},
+			SystemCall{MethodName: "d.close", Params: []interface{}{hash}},
+			SystemCall{MethodName: "d.directory.set", Params: []interface{}{hash, *req.Directory}},
+		)
+		if t.State == 1 {
+			calls = append(calls, SystemCall{MethodName: "d.start", Params: []interface{}{hash}})
+			if t.IsActive == 0 {
+				calls = append(calls, SystemCall{MethodName: "d.pause", Params: []interface{}{hash}})
+			}
+		}
+	}
+
+	if len(calls) == 0 {
+		return nil, fmt.Errorf("%w: priority, label or directory is required", ErrInvalidArgument)
+	}
+	return calls, nil
+}
+
+// Resolves the hashes an action request targets, either listed explicitly
+// or matched by a filter over a view. Hashes are limited to the scope of the user of the context.
+func actionHashes(ctx context.Context, rt *Rtorrent, req ActionRequest) ([]string, error) {
+	if len(req.Hashes) > 0 && req.Filter != "" {
+		return nil, fmt.Errorf("%w: hashes and filter are mutually exclusive", ErrInvalidArgument)
+	}
+
+	if len(req.Hashes) > 0 {
+		hashes := make([]string, 0, len(req.Hashes))
+		seen := make(map[string]bool)
+		for _, hash := range req.Hashes {
+			if !infoHashRx.MatchString(hash) {
+				return nil, fmt.Errorf("%w: invalid hash %q", ErrInvalidArgument, hash)
+			}
+			hash = strings.ToUpper(hash)
+			if seen[hash] {
+				continue
+			}
+			seen[hash] = true
+			hashes = append(hashes, hash)
+		}
+
+		if len(scopeFromContext(ctx)) == 0 {
+			return hashes, nil
+		}
+		current, err := torrentLabels(ctx, rt)
+		if err != nil {
+			return nil, err
+		}
+		for _, hash := range hashes {
+			if _, ok := current[hash]; !ok {
+				return nil, fmt.Errorf("%w: torrent %s is outside your labels", ErrForbidden, hash)
+			}
+		}
+		return hashes, nil
+	}
+
+	if req.Filter == "" {
+		return nil, fmt.Errorf("%w: hashes or filter is required", ErrInvalidArgument)
+	}
+
+	values, err := url.ParseQuery(req.Filter)
+	if err != nil {
+		return nil, fmt.Errorf("%w: filter: %s", ErrInvalidArgument, err)
+	}
+	query, err := ParseViewQuery(values)
+	if err != nil {
+		return nil, err
+	}
+	query.LabelDelimiter = rt.labelDelimiter
+	query.Scope = scopeFromContext(ctx)
+
+	view := req.View
+	if view == "" {
+		view = "main"
+	}
+
+	commands, err := fieldCommands[Torrent](parseFields(append([]string{"hash"}, query.Fields()...)))
+	if err != nil {
+		return nil, err
+	}
+	torrents, err := multicall[Torrent](ctx, rt, "d.multicall2", []interface{}{"", view}, commands)
+	if err != nil {
+		return nil, err
+	}
+	torrents, _ = query.Apply(torrents)
+
+	hashes := make([]string, 0, len(torrents))
+	for _, t := range torrents {
+		hashes = append(hashes, t.Hash)
+	}
+	return hashes, nil
+}
+
+// Performs the calls in a single system.multicall and returns a result for each hash,
+// calls must be in the same order as hashes. Each hash is added to the audit log.
+func actionResults(ctx context.Context, rt *Rtorrent, hashes []string, calls []SystemCall) ([]ActionResult, error) {
+	results := make([]ActionResult, 0, len(hashes))
+	if len(calls) == 0 {
+		return results, nil
+	}
+
+	// names are fetched first because erased torrents have none
+	names := auditNames(ctx, rt, hashes)
+	errs, err := rt.BatchContext(ctx, calls)
+	if err != nil {
+		return nil, err
+	}
+	for i, hash := range hashes {
+		auditTorrent(ctx, hash, names[hash], errs[i])
+		result := ActionResult{Hash: hash, Status: "ok"}
+		if errs[i] != nil {
+			_, code := errorStatus(errs[i])
+			result.Status = "error"
+			result.Code = code
+			result.Error = errs[i].Error()
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+// Returns ok if all results are ok
+func actionStatus(results []ActionResult) string {
+	for _, result := range results {
+		if result.Status != "ok" {
+			return "error"
+		}
+	}
+	return "ok"
+}
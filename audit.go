@@ -0,0 +1,492 @@
+package kahva
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/rs/zerolog/log"
+)
+
+// Request bodies larger than this are not recorded as parameters
+const maxAuditParams = 64 << 10
+
+// AuditConfig configures the audit log
+type AuditConfig struct {
+	// JSON lines file entries are appended to
+	File string
+	// The file is rotated once it would grow beyond this many bytes
+	MaxSize int64
+	// Number of rotated files kept as File.1 (newest) to File.N
+	MaxBackups int
+}
+
+// Default rotation of the audit log
+var DefaultAuditConfig = AuditConfig{
+	MaxSize:    10 << 20,
+	MaxBackups: 5,
+}
+
+// AuditEntry records a mutating API request. Requests on many torrents are recorded
+// with an entry for each torrent, requests on no torrent (e.g. throttle) without a hash.
+type AuditEntry struct {
+	Time     time.Time `json:"time"`
+	User     string    `json:"user,omitempty"`
+	ClientIP string    `json:"client_ip"`
+	Method   string    `json:"method"`
+	Action   string    `json:"action"`
+	Hash     string    `json:"hash,omitempty"`
+	Name     string    `json:"name,omitempty"`
+	// JSON request body or form values, uploaded files are recorded by name
+	Params json.RawMessage `json:"params,omitempty"`
+	// ok or error
+	Status     string `json:"status"`
+	StatusCode int    `json:"status_code"`
+	Error      string `json:"error,omitempty"`
+}
+
+// AuditLog appends entries to a JSON lines file which is rotated by size
+type AuditLog struct {
+	rt     *Rtorrent
+	config AuditConfig
+
+	mu   sync.Mutex
+	file *os.File
+	size int64
+}
+
+// Creates a new instance of AuditLog and opens the file. rt is used to look up torrent names.
+func NewAuditLog(rt *Rtorrent, config AuditConfig) (*AuditLog, error) {
+	if config.File == "" {
+		return nil, fmt.Errorf("%w: audit file is required", ErrInvalidArgument)
+	}
+	a := &AuditLog{
+		rt:     rt,
+		config: config,
+	}
+	err := a.open()
+	if err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+func (a *AuditLog) open() error {
+	file, err := os.OpenFile(a.config.File, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	a.file = file
+	a.size = info.Size()
+	return nil
+}
+
+// Closes the file
+func (a *AuditLog) Close() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.file.Close()
+}
+
+// Appends entries to the file
+func (a *AuditLog) write(entries []AuditEntry) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	for _, entry := range entries {
+		line, err := json.Marshal(entry)
+		if err != nil {
+			return err
+		}
+		line = append(line, '\n')
+
+		if a.config.MaxSize > 0 && a.size > 0 && a.size+int64(len(line)) > a.config.MaxSize {
+			err := a.rotate()
+			if err != nil {
+				return err
+			}
+		}
+		n, err := a.file.Write(line)
+		a.size += int64(n)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Shifts File.1 to File.2 and so on, the oldest file is removed. Must be called with the lock held.
+func (a *AuditLog) rotate() error {
+	err := a.file.Close()
+	if err != nil {
+		return err
+	}
+
+	for i := a.config.MaxBackups - 1; i >= 1; i-- {
+		err := os.Rename(a.backup(i), a.backup(i+1))
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+	if a.config.MaxBackups > 0 {
+		err = os.Rename(a.config.File, a.backup(1))
+	} else {
+		err = os.Remove(a.config.File)
+	}
+	if err != nil {
+		return err
+	}
+	return a.open()
+}
+
+func (a *AuditLog) backup(i int) string {
+	return a.config.File + "." + strconv.Itoa(i)
+}
+
+// AuditQuery filters audit log entries
+type AuditQuery struct {
+	// Entries at or after Since and before Until, zero values do not filter
+	Since time.Time
+	Until time.Time
+	Hash  string
+	// Zero means no limit
+	Limit int
+}
+
+// Parses audit query parameters. Times are RFC 3339 timestamps.
+func ParseAuditQuery(values url.Values) (AuditQuery, error) {
+	q := AuditQuery{Hash: strings.ToUpper(values.Get("hash"))}
+
+	for key, t := range map[string]*time.Time{"since": &q.Since, "until": &q.Until} {
+		if values.Get(key) == "" {
+			continue
+		}
+		parsed, err := time.Parse(time.RFC3339, values.Get(key))
+		if err != nil {
+			return q, fmt.Errorf("%w: %s must be an RFC 3339 timestamp", ErrInvalidArgument, key)
+		}
+		*t = parsed
+	}
+
+	if value := values.Get("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit < 0 {
+			return q, fmt.Errorf("%w: limit must be a non-negative integer", ErrInvalidArgument)
+		}
+		q.Limit = limit
+	}
+	return q, nil
+}
+
+// Returns true if the entry matches all filters of the query
+func (q AuditQuery) Match(entry AuditEntry) bool {
+	if !q.Since.IsZero() && entry.Time.Before(q.Since) {
+		return false
+	}
+	if !q.Until.IsZero() && !entry.Time.Before(q.Until) {
+		return false
+	}
+	if q.Hash != "" && entry.Hash != q.Hash {
+		return false
+	}
+	return true
+}
+
+// Returns the entries matching the query from the file and its backups, newest first
+func (a *AuditLog) Query(q AuditQuery) ([]AuditEntry, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	entries := make([]AuditEntry, 0)
+	paths := []string{a.config.File}
+	for i := 1; i <= a.config.MaxBackups; i++ {
+		paths = append(paths, a.backup(i))
+	}
+	for _, path := range paths {
+		matched, err := readAudit(path, q)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, matched...)
+		if q.Limit > 0 && len(entries) >= q.Limit {
+			break
+		}
+	}
+
+	// entries with the same time keep their order in the file
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].Time.After(entries[j].Time)
+	})
+	if q.Limit > 0 && len(entries) > q.Limit {
+		entries = entries[:q.Limit]
+	}
+	return entries, nil
+}
+
+// Returns the entries of a file matching the query, newest first
+func readAudit(path string, q AuditQuery) ([]AuditEntry, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	entries := make([]AuditEntry, 0)
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64<<10), 2*maxAuditParams)
+	for scanner.Scan() {
+		var entry AuditEntry
+		err := json.Unmarshal(scanner.Bytes(), &entry)
+		if err != nil {
+			// a line can be cut off if kahva stopped while writing it
+			log.Warn().Err(err).Str("file", path).Msg("skipping invalid audit log line")
+			continue
+		}
+		if q.Match(entry) {
+			entries = append(entries, entry)
+		}
+	}
+	for i, j := 0, len(entries)-1; i < j; i, j = i+1, j-1 {
+		entries[i], entries[j] = entries[j], entries[i]
+	}
+	return entries, scanner.Err()
+}
+
+type auditKey struct{}
+
+// auditRecord collects the torrents a request acted on
+type auditRecord struct {
+	mu       sync.Mutex
+	torrents []auditedTorrent
+}
+
+type auditedTorrent struct {
+	hash string
+	name string
+	err  error
+}
+
+// Returns true if the request of the context is recorded in the audit log
+func auditing(ctx context.Context) bool {
+	_, ok := ctx.Value(auditKey{}).(*auditRecord)
+	return ok
+}
+
+// Adds a torrent and the outcome of the action on it to the audit log entries of a request
+func auditTorrent(ctx context.Context, hash string, name string, err error) {
+	record, ok := ctx.Value(auditKey{}).(*auditRecord)
+	if !ok {
+		return
+	}
+	record.mu.Lock()
+	defer record.mu.Unlock()
+	record.torrents = append(record.torrents, auditedTorrent{hash: strings.ToUpper(hash), name: name, err: err})
+}
+
+// Fetches the names of torrents for the audit log in a single system.multicall. Returns nil
+// if the request of the context is not audited, names of torrents that can not be fetched are empty.
+func auditNames(ctx context.Context, rt *Rtorrent, hashes []string) map[string]string {
+	if !auditing(ctx) || len(hashes) == 0 {
+		return nil
+	}
+	calls := make([]SystemCall, 0, len(hashes))
+	for _, hash := range hashes {
+		calls = append(calls, SystemCall{MethodName: "d.name", Params: []interface{}{hash}})
+	}
+	values, _, err := rt.batch(ctx, calls)
+	if err != nil {
+		log.Error().Err(err).Msg("cant fetch torrent names for audit log")
+		return nil
+	}
+
+	names := make(map[string]string, len(hashes))
+	for i, hash := range hashes {
+		names[hash], _ = values[i].(string)
+	}
+	return names
+}
+
+// Records mutating requests. Handlers acting on many torrents add them with
+// auditTorrent, otherwise the hash route variable is recorded.
+func (a *AuditLog) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !mutating(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		action := auditAction(r)
+
+		entry := AuditEntry{
+			Time:     time.Now().UTC(),
+			ClientIP: r.RemoteAddr,
+			Method:   r.Method,
+			Action:   action,
+			Hash:     strings.ToUpper(mux.Vars(r)["hash"]),
+		}
+		if user, ok := UserFromContext(r.Context()); ok {
+			entry.User = user.Name
+		}
+		if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+			entry.ClientIP = host
+		}
+		// the torrent may not exist anymore after the request
+		if entry.Hash != "" {
+			err := a.rt.call(r.Context(), "d.name", entry.Hash, &entry.Name)
+			if err != nil {
+				entry.Name = ""
+			}
+		}
+
+		ct, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		if ct == "application/json" {
+			body, err := io.ReadAll(io.LimitReader(r.Body, maxAuditParams+1))
+			if err == nil && len(body) <= maxAuditParams && json.Valid(body) {
+				entry.Params = compactJSON(body)
+			}
+			r.Body = struct {
+				io.Reader
+				io.Closer
+			}{io.MultiReader(bytes.NewReader(body), r.Body), r.Body}
+		}
+
+		record := &auditRecord{}
+		req := r.WithContext(context.WithValue(r.Context(), auditKey{}, record))
+		sw := &auditWriter{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(sw, req)
+
+		if entry.Params == nil && req.MultipartForm != nil {
+			entry.Params = formParams(req.MultipartForm.Value, req.MultipartForm.File)
+		} else if entry.Params == nil && r.URL.RawQuery != "" {
+			entry.Params = formParams(r.URL.Query(), nil)
+		}
+		entry.StatusCode = sw.status
+		entry.Status = "ok"
+		if sw.status >= http.StatusBadRequest {
+			entry.Status = "error"
+			var response ErrorResponse
+			if json.Unmarshal(sw.body.Bytes(), &response) == nil {
+				entry.Error = response.Message
+			}
+		}
+
+		entries := []AuditEntry{entry}
+		if len(record.torrents) > 0 {
+			entries = make([]AuditEntry, 0, len(record.torrents))
+			for _, t := range record.torrents {
+				e := entry
+				e.Hash = t.hash
+				e.Name = t.name
+				if t.err != nil {
+					e.Status = "error"
+					e.Error = t.err.Error()
+				}
+				entries = append(entries, e)
+			}
+		}
+
+		err := a.write(entries)
+		if err != nil {
+			log.Error().Err(err).Str("action", entry.Action).Msg("cant write audit log")
+		}
+	})
+}
+
+// Prefixes of route templates which are not part of the audited action
+var auditPrefixRx = regexp.MustCompile(`^/api/(v1/)?(torrents?/\{hash[^}]*\}/)?`)
+
+// Returns the action of a request, the action route variable, the route name or the route
+// path without the api and torrent prefixes (e.g. erase, files/priority or throttle)
+func auditAction(r *http.Request) string {
+	if action := mux.Vars(r)["action"]; action != "" {
+		return action
+	}
+	route := mux.CurrentRoute(r)
+	if route == nil {
+		return r.URL.Path
+	}
+	if route.GetName() != "" {
+		return route.GetName()
+	}
+	template, err := route.GetPathTemplate()
+	if err != nil {
+		return r.URL.Path
+	}
+	return auditPrefixRx.ReplaceAllString(template, "")
+}
+
+func compactJSON(body []byte) json.RawMessage {
+	var b bytes.Buffer
+	err := json.Compact(&b, body)
+	if err != nil {
+		return nil
+	}
+	return b.Bytes()
+}
+
+// Returns form values and the names of uploaded files as JSON
+func formParams(values map[string][]string, files map[string][]*multipart.FileHeader) json.RawMessage {
+	params := make(map[string][]string, len(values)+len(files))
+	for key, v := range values {
+		params[key] = v
+	}
+	for key, headers := range files {
+		for _, header := range headers {
+			params[key] = append(params[key], header.Filename)
+		}
+	}
+	b, err := json.Marshal(params)
+	if err != nil {
+		return nil
+	}
+	return b
+}
+
+// auditWriter keeps the status code and the body of error responses
+type auditWriter struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (w *auditWriter) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *auditWriter) Write(b []byte) (int, error) {
+	if w.status >= http.StatusBadRequest && w.body.Len() < maxAuditParams {
+		w.body.Write(b)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// Allows http.ResponseController to reach the underlying writer
+func (w *auditWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
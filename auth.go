@@ -0,0 +1,448 @@
+package kahva
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// ErrUnauthorized is returned when a request has no valid credentials
+var ErrUnauthorized = errors.New("unauthorized")
+
+// Name of the session cookie
+const SessionCookie = "kahva_session"
+
+// Prefix of API tokens so that they are easy to recognize
+const tokenPrefix = "kahva_"
+
+// AuthConfig configures authentication of API clients
+type AuthConfig struct {
+	// htpasswd style file with one user:bcrypt-hash[:role[:labels]] per line. The role is read-only
+	// if it is not set. Users with comma separated labels can only access torrents with one of them.
+	UsersFile string
+	// File API tokens are stored in, created if it does not exist
+	TokensFile string
+	// Lifetime of a session cookie
+	SessionTTL time.Duration
+	// Only send session cookies over HTTPS
+	SecureCookie bool
+	// Origins other than the host of the API that may send state changing
+	// requests authenticated with a session cookie, e.g. https://kahva.example.com
+	Origins []string
+}
+
+// User is an authenticated user
+type User struct {
+	Name string `json:"name"`
+	Role string `json:"role"`
+	// Labels the user is restricted to, empty if the user can access every torrent
+	Labels []string `json:"labels,omitempty"`
+}
+
+// TokenInfo describes an API token without its secret
+type TokenInfo struct {
+	Name string `json:"name"`
+	User string `json:"user"`
+}
+
+type authUser struct {
+	hash   []byte
+	role   string
+	labels []string
+}
+
+type session struct {
+	user    User
+	expires time.Time
+}
+
+// Auth authenticates requests with session cookies or bearer API tokens
+type Auth struct {
+	config AuthConfig
+	// bcrypt hash of a random password, compared against when the user does not
+	// exist so that logins take the same time for unknown users
+	dummy []byte
+
+	mu    sync.Mutex
+	users map[string]authUser
+	// tokens keyed by the hex SHA-256 of the token
+	tokens   map[string]TokenInfo
+	sessions map[string]session
+}
+
+// Creates a new instance of Auth and reads the users and tokens files
+func NewAuth(config AuthConfig) (*Auth, error) {
+	dummy, err := bcrypt.GenerateFromPassword([]byte(tokenPrefix), bcrypt.DefaultCost)
+	if err != nil {
+		return nil, err
+	}
+
+	a := &Auth{
+		config:   config,
+		dummy:    dummy,
+		users:    make(map[string]authUser),
+		tokens:   make(map[string]TokenInfo),
+		sessions: make(map[string]session),
+	}
+
+	lines, err := readLines(config.UsersFile)
+	if err != nil {
+		return nil, err
+	}
+	for i, line := range lines {
+		parts := strings.Split(line, ":")
+		if len(parts) < 2 || len(parts) > 4 || parts[0] == "" || !strings.HasPrefix(parts[1], "$2") {
+			return nil, fmt.Errorf("%s:%d: expected user:bcrypt-hash[:role[:labels]]", config.UsersFile, i+1)
+		}
+		u := authUser{hash: []byte(parts[1]), role: RoleReadOnly}
+		if len(parts) > 2 && parts[2] != "" {
+			u.role = parts[2]
+		}
+		if _, ok := roleLevels[u.role]; !ok {
+			return nil, fmt.Errorf("%s:%d: unknown role %q", config.UsersFile, i+1, u.role)
+		}
+		if len(parts) > 3 {
+			u.labels = parseLabels(parts[3], ",")
+		}
+		a.users[parts[0]] = u
+	}
+	if len(a.users) == 0 {
+		return nil, fmt.Errorf("%s: no users", config.UsersFile)
+	}
+
+	if config.TokensFile == "" {
+		return a, nil
+	}
+	lines, err = readLines(config.TokensFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return a, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	for i, line := range lines {
+		parts := strings.Split(line, ":")
+		if len(parts) != 3 {
+			return nil, fmt.Errorf("%s:%d: expected user:name:sha256", config.TokensFile, i+1)
+		}
+		a.tokens[parts[2]] = TokenInfo{User: parts[0], Name: parts[1]}
+	}
+	return a, nil
+}
+
+// Returns the lines of a file without empty lines and comments
+func readLines(path string) ([]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	lines := make([]string, 0)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		lines = append(lines, line)
+	}
+	return lines, scanner.Err()
+}
+
+// Checks a password and starts a session. Returns the session ID.
+func (a *Auth) Login(name string, password string) (string, User, error) {
+	a.mu.Lock()
+	u, ok := a.users[name]
+	a.mu.Unlock()
+	hash := u.hash
+	if !ok {
+		hash = a.dummy
+	}
+
+	err := bcrypt.CompareHashAndPassword(hash, []byte(password))
+	if err != nil || !ok {
+		return "", User{}, fmt.Errorf("%w: invalid username or password", ErrUnauthorized)
+	}
+
+	id, err := randomToken()
+	if err != nil {
+		return "", User{}, err
+	}
+	user := User{Name: name, Role: u.role, Labels: u.labels}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	now := time.Now()
+	for key, s := range a.sessions {
+		if now.After(s.expires) {
+			delete(a.sessions, key)
+		}
+	}
+	a.sessions[hashToken(id)] = session{user: user, expires: now.Add(a.config.SessionTTL)}
+	return id, user, nil
+}
+
+// Ends a session
+func (a *Auth) Logout(id string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	delete(a.sessions, hashToken(id))
+}
+
+// Returns the bearer token of a request. Other authorization schemes, e.g. basic
+// authentication sent by browsers to static files, are not tokens.
+func bearerToken(r *http.Request) (string, bool) {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return strings.TrimSpace(token), ok
+}
+
+// Returns the user of a request authenticated with a bearer token or a session cookie
+func (a *Auth) authenticate(r *http.Request) (User, error) {
+	if token, ok := bearerToken(r); ok {
+		a.mu.Lock()
+		info, ok := a.tokens[hashToken(token)]
+		// tokens of users removed from the users file are not valid
+		var u authUser
+		if ok {
+			u, ok = a.users[info.User]
+		}
+		a.mu.Unlock()
+		if !ok {
+			return User{}, fmt.Errorf("%w: invalid token", ErrUnauthorized)
+		}
+		return User{Name: info.User, Role: u.role, Labels: u.labels}, nil
+	}
+
+	cookie, err := r.Cookie(SessionCookie)
+	if err != nil {
+		return User{}, fmt.Errorf("%w: no credentials", ErrUnauthorized)
+	}
+	a.mu.Lock()
+	s, ok := a.sessions[hashToken(cookie.Value)]
+	a.mu.Unlock()
+	if !ok || time.Now().After(s.expires) {
+		return User{}, fmt.Errorf("%w: session expired", ErrUnauthorized)
+	}
+	return s.user, nil
+}
+
+// Creates an API token for a user and stores it in the tokens file. The token
+// itself is only returned here, the file contains its hash.
+func (a *Auth) CreateToken(user User, name string) (string, error) {
+	if a.config.TokensFile == "" {
+		return "", fmt.Errorf("%w: tokens are disabled", ErrInvalidArgument)
+	}
+	if name == "" || strings.ContainsAny(name, ":\r\n") {
+		return "", fmt.Errorf("%w: token name must not be empty or contain colons", ErrInvalidArgument)
+	}
+
+	secret, err := randomToken()
+	if err != nil {
+		return "", err
+	}
+	token := tokenPrefix + secret
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for _, info := range a.tokens {
+		if info.User == user.Name && info.Name == name {
+			return "", fmt.Errorf("%w: token %q already exists", ErrInvalidArgument, name)
+		}
+	}
+
+	tokens := make(map[string]TokenInfo, len(a.tokens)+1)
+	for hash, info := range a.tokens {
+		tokens[hash] = info
+	}
+	tokens[hashToken(token)] = TokenInfo{User: user.Name, Name: name}
+	err = a.writeTokens(tokens)
+	if err != nil {
+		return "", err
+	}
+	a.tokens = tokens
+	return token, nil
+}
+
+// Deletes an API token of a user
+func (a *Auth) DeleteToken(user User, name string) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	tokens := make(map[string]TokenInfo, len(a.tokens))
+	found := false
+	for hash, info := range a.tokens {
+		if info.User == user.Name && info.Name == name {
+			found = true
+			continue
+		}
+		tokens[hash] = info
+	}
+	if !found {
+		return fmt.Errorf("%w: token %q", ErrNotFound, name)
+	}
+
+	err := a.writeTokens(tokens)
+	if err != nil {
+		return err
+	}
+	a.tokens = tokens
+	return nil
+}
+
+// Returns the API tokens of a user sorted by name
+func (a *Auth) Tokens(user User) []TokenInfo {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	tokens := make([]TokenInfo, 0)
+	for _, info := range a.tokens {
+		if info.User == user.Name {
+			tokens = append(tokens, info)
+		}
+	}
+	sort.Slice(tokens, func(i, j int) bool {
+		return tokens[i].Name < tokens[j].Name
+	})
+	return tokens
+}
+
+// Replaces the tokens file, the new file is written next to it and renamed
+func (a *Auth) writeTokens(tokens map[string]TokenInfo) error {
+	lines := make([]string, 0, len(tokens))
+	for hash, info := range tokens {
+		lines = append(lines, info.User+":"+info.Name+":"+hash)
+	}
+	sort.Strings(lines)
+
+	tmp := a.config.TokensFile + ".tmp"
+	err := os.WriteFile(tmp, []byte(strings.Join(lines, "\n")+"\n"), 0o600)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, a.config.TokensFile)
+}
+
+// Returns a random hex encoded 256 bit value
+func randomToken() (string, error) {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// Tokens and session IDs are random, a fast hash is enough to not keep them in plain text
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// Returns a session cookie, an empty ID expires the cookie
+func (a *Auth) cookie(id string, r *http.Request) *http.Cookie {
+	cookie := &http.Cookie{
+		Name:     SessionCookie,
+		Value:    id,
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   a.config.SecureCookie || r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	}
+	if id == "" {
+		cookie.MaxAge = -1
+	} else {
+		cookie.Expires = time.Now().Add(a.config.SessionTTL)
+	}
+	return cookie
+}
+
+type userKey struct{}
+
+// Returns the authenticated user of a request context
+func UserFromContext(ctx context.Context) (User, bool) {
+	user, ok := ctx.Value(userKey{}).(User)
+	return user, ok
+}
+
+// Rejects requests without a valid session cookie or bearer token. Browsers send
+// session cookies along with requests started by other sites, so state changing
+// requests authenticated with a cookie must come from an allowed origin.
+func (a *Auth) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, err := a.authenticate(r)
+		if _, ok := bearerToken(r); err == nil && !ok {
+			err = a.checkSession(r)
+		}
+		if err != nil {
+			respondError(err, w)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), userKey{}, user)))
+	})
+}
+
+// Checks a request authenticated with a session cookie against cross-site request forgery.
+// Lax cookies are sent on cross-site navigations, so GET requests that change state are
+// only accepted with a bearer token.
+func (a *Auth) checkSession(r *http.Request) error {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		if mutating(r) {
+			return fmt.Errorf("%w: state changing GET requests require a bearer token, use POST instead", ErrForbidden)
+		}
+		return nil
+	}
+
+	// browsers send an origin with every other method, requests without one are not cross-site
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return nil
+	}
+	for _, allowed := range a.config.Origins {
+		if origin == allowed {
+			return nil
+		}
+	}
+	// a proxy in front of kahva may change the host
+	u, err := url.Parse(origin)
+	if err == nil && u.Host != "" && (u.Host == r.Host || u.Host == r.Header.Get("X-Forwarded-Host")) {
+		return nil
+	}
+	return fmt.Errorf("%w: cross-origin request from %s", ErrForbidden, origin)
+}
+
+// Protects static files with a session cookie or HTTP basic authentication,
+// browsers prompt for credentials if there is no session. Browsers keep sending
+// the credentials with every request, a valid session is used before them so that
+// passwords are only checked and sessions only started when there is none.
+func (a *Auth) StaticMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, err := a.authenticate(r); err == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if name, password, ok := r.BasicAuth(); ok {
+			if id, _, err := a.Login(name, password); err == nil {
+				http.SetCookie(w, a.cookie(id, r))
+				next.ServeHTTP(w, r)
+				return
+			}
+		}
+		w.Header().Set("WWW-Authenticate", `Basic realm="kahva", charset="UTF-8"`)
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+	})
+}
@@ -0,0 +1,309 @@
+package kahva
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var errBencode = errors.New("invalid bencode")
+
+// Maximum nesting of lists and dictionaries
+const bencodeMaxDepth = 64
+
+// bencodeDecoder decodes bencoded values into int64, string, []interface{} and map[string]interface{}
+type bencodeDecoder struct {
+	data []byte
+	pos  int
+	// offsets of the top level info dictionary
+	infoStart, infoEnd int
+}
+
+// Decodes a single bencoded value which must span all of data
+func decodeBencode(data []byte) (interface{}, *bencodeDecoder, error) {
+	d := &bencodeDecoder{data: data, infoStart: -1}
+	v, err := d.value(0)
+	if err != nil {
+		return nil, nil, err
+	}
+	if d.pos != len(data) {
+		return nil, nil, fmt.Errorf("%w: trailing data at offset %d", errBencode, d.pos)
+	}
+	return v, d, nil
+}
+
+func (d *bencodeDecoder) value(depth int) (interface{}, error) {
+	if depth > bencodeMaxDepth {
+		return nil, fmt.Errorf("%w: nesting too deep", errBencode)
+	}
+	if d.pos >= len(d.data) {
+		return nil, fmt.Errorf("%w: unexpected end of data", errBencode)
+	}
+
+	switch c := d.data[d.pos]; {
+	case c == 'i':
+		return d.integer()
+	case c >= '0' && c <= '9':
+		return d.string()
+	case c == 'l':
+		d.pos++
+		list := make([]interface{}, 0)
+		for {
+			if d.pos >= len(d.data) {
+				return nil, fmt.Errorf("%w: unterminated list", errBencode)
+			}
+			if d.data[d.pos] == 'e' {
+				d.pos++
+				return list, nil
+			}
+			v, err := d.value(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, v)
+		}
+	case c == 'd':
+		d.pos++
+		dict := make(map[string]interface{})
+		for {
+			if d.pos >= len(d.data) {
+				return nil, fmt.Errorf("%w: unterminated dictionary", errBencode)
+			}
+			if d.data[d.pos] == 'e' {
+				d.pos++
+				return dict, nil
+			}
+			if d.data[d.pos] < '0' || d.data[d.pos] > '9' {
+				return nil, fmt.Errorf("%w: dictionary key must be a string at offset %d", errBencode, d.pos)
+			}
+			key, err := d.string()
+			if err != nil {
+				return nil, err
+			}
+			if _, ok := dict[key]; ok {
+				return nil, fmt.Errorf("%w: duplicate dictionary key %q", errBencode, key)
+			}
+			start := d.pos
+			v, err := d.value(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			if depth == 0 && key == "info" {
+				d.infoStart, d.infoEnd = start, d.pos
+			}
+			dict[key] = v
+		}
+	}
+	return nil, fmt.Errorf("%w: unexpected byte %q at offset %d", errBencode, d.data[d.pos], d.pos)
+}
+
+func (d *bencodeDecoder) integer() (int64, error) {
+	end := bytes.IndexByte(d.data[d.pos:], 'e')
+	if end < 0 {
+		return 0, fmt.Errorf("%w: unterminated integer", errBencode)
+	}
+	s := string(d.data[d.pos+1 : d.pos+end])
+	if s == "" || s == "-0" || (strings.HasPrefix(s, "0") && s != "0") || strings.HasPrefix(s, "-0") || strings.HasPrefix(s, "+") {
+		return 0, fmt.Errorf("%w: invalid integer %q", errBencode, s)
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%w: invalid integer %q", errBencode, s)
+	}
+	d.pos += end + 1
+	return n, nil
+}
+
+func (d *bencodeDecoder) string() (string, error) {
+	colon := bytes.IndexByte(d.data[d.pos:], ':')
+	if colon < 0 {
+		return "", fmt.Errorf("%w: unterminated string length", errBencode)
+	}
+	prefix := string(d.data[d.pos : d.pos+colon])
+	if len(prefix) > 1 && prefix[0] == '0' {
+		return "", fmt.Errorf("%w: invalid string length %q", errBencode, prefix)
+	}
+	n, err := strconv.Atoi(prefix)
+	if err != nil || n < 0 {
+		return "", fmt.Errorf("%w: invalid string length %q", errBencode, prefix)
+	}
+	start := d.pos + colon + 1
+	if n > len(d.data)-start {
+		return "", fmt.Errorf("%w: string length out of range", errBencode)
+	}
+	d.pos = start + n
+	return string(d.data[start:d.pos]), nil
+}
+
+// TorrentMeta is the metadata of a .torrent file
+type TorrentMeta struct {
+	// Upper case hex SHA-1 of the info dictionary, used by rTorrent to identify the torrent
+	Hash string `json:"hash"`
+	// Hex SHA-256 of the info dictionary for v2 and hybrid torrents
+	HashV2 string     `json:"hash_v2,omitempty"`
+	Name   string     `json:"name"`
+	Size   int64      `json:"size"`
+	Files  []MetaFile `json:"files"`
+}
+
+// MetaFile is a file listed in a .torrent file
+type MetaFile struct {
+	Path string `json:"path"`
+	Size int64  `json:"size"`
+}
+
+// Validates a .torrent file and returns its metadata
+func ParseTorrent(data []byte, maxSize int64) (TorrentMeta, error) {
+	meta := TorrentMeta{}
+	if maxSize > 0 && int64(len(data)) > maxSize {
+		return meta, fmt.Errorf("%w: torrent is larger than %d bytes", ErrInvalidArgument, maxSize)
+	}
+
+	v, d, err := decodeBencode(data)
+	if err != nil {
+		return meta, fmt.Errorf("%w: %s", ErrInvalidArgument, err)
+	}
+	root, ok := v.(map[string]interface{})
+	if !ok {
+		return meta, fmt.Errorf("%w: torrent must be a dictionary", ErrInvalidArgument)
+	}
+	info, ok := root["info"].(map[string]interface{})
+	if !ok {
+		return meta, fmt.Errorf("%w: torrent has no info dictionary", ErrInvalidArgument)
+	}
+
+	raw := data[d.infoStart:d.infoEnd]
+	sum := sha1.Sum(raw)
+	meta.Hash = strings.ToUpper(hex.EncodeToString(sum[:]))
+
+	meta.Name, ok = info["name"].(string)
+	if !ok || meta.Name == "" {
+		return meta, fmt.Errorf("%w: torrent has no name", ErrInvalidArgument)
+	}
+	if pieceLength, ok := info["piece length"].(int64); !ok || pieceLength <= 0 {
+		return meta, fmt.Errorf("%w: torrent has no valid piece length", ErrInvalidArgument)
+	}
+
+	version, _ := info["meta version"].(int64)
+	if version == 2 {
+		sum := sha256.Sum256(raw)
+		meta.HashV2 = hex.EncodeToString(sum[:])
+	}
+
+	_, hasLength := info["length"]
+	_, hasFiles := info["files"]
+	switch {
+	case hasLength || hasFiles:
+		pieces, ok := info["pieces"].(string)
+		if !ok || len(pieces)%20 != 0 {
+			return meta, fmt.Errorf("%w: torrent has no valid pieces", ErrInvalidArgument)
+		}
+		meta.Files, err = metaFilesV1(meta.Name, info)
+	case version == 2:
+		tree, ok := info["file tree"].(map[string]interface{})
+		if !ok {
+			return meta, fmt.Errorf("%w: torrent has no file tree", ErrInvalidArgument)
+		}
+		meta.Files, err = metaFilesV2(tree, nil, 0)
+	default:
+		return meta, fmt.Errorf("%w: torrent has no files", ErrInvalidArgument)
+	}
+	if err != nil {
+		return meta, err
+	}
+
+	for _, f := range meta.Files {
+		meta.Size += f.Size
+	}
+	return meta, nil
+}
+
+// Returns files of a v1 info dictionary, paths are relative to the torrent name
+func metaFilesV1(name string, info map[string]interface{}) ([]MetaFile, error) {
+	if length, ok := info["length"]; ok {
+		size, ok := length.(int64)
+		if !ok || size < 0 {
+			return nil, fmt.Errorf("%w: torrent has invalid length", ErrInvalidArgument)
+		}
+		return []MetaFile{{Path: name, Size: size}}, nil
+	}
+
+	list, ok := info["files"].([]interface{})
+	if !ok || len(list) == 0 {
+		return nil, fmt.Errorf("%w: torrent has invalid files", ErrInvalidArgument)
+	}
+
+	files := make([]MetaFile, 0, len(list))
+	for i, item := range list {
+		file, ok := item.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("%w: file %d is not a dictionary", ErrInvalidArgument, i)
+		}
+		// skip padding files of hybrid torrents
+		if attr, _ := file["attr"].(string); strings.Contains(attr, "p") {
+			continue
+		}
+		size, ok := file["length"].(int64)
+		if !ok || size < 0 {
+			return nil, fmt.Errorf("%w: file %d has invalid length", ErrInvalidArgument, i)
+		}
+		parts, ok := file["path"].([]interface{})
+		if !ok || len(parts) == 0 {
+			return nil, fmt.Errorf("%w: file %d has invalid path", ErrInvalidArgument, i)
+		}
+		path := make([]string, 0, len(parts))
+		for _, part := range parts {
+			s, ok := part.(string)
+			if !ok || s == "" || s == "." || s == ".." || strings.Contains(s, "/") {
+				return nil, fmt.Errorf("%w: file %d has invalid path", ErrInvalidArgument, i)
+			}
+			path = append(path, s)
+		}
+		files = append(files, MetaFile{Path: strings.Join(path, "/"), Size: size})
+	}
+	return files, nil
+}
+
+// Returns files of a v2 file tree. Files are dictionaries with an empty key.
+func metaFilesV2(tree map[string]interface{}, prefix []string, depth int) ([]MetaFile, error) {
+	if depth > bencodeMaxDepth {
+		return nil, fmt.Errorf("%w: file tree too deep", ErrInvalidArgument)
+	}
+
+	files := make([]MetaFile, 0)
+	for name, node := range tree {
+		dict, ok := node.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("%w: invalid file tree entry %q", ErrInvalidArgument, name)
+		}
+
+		if name == "" {
+			size, ok := dict["length"].(int64)
+			if !ok || size < 0 || len(prefix) == 0 {
+				return nil, fmt.Errorf("%w: invalid file %q", ErrInvalidArgument, strings.Join(prefix, "/"))
+			}
+			files = append(files, MetaFile{Path: strings.Join(prefix, "/"), Size: size})
+			continue
+		}
+		if name == "." || name == ".." || strings.Contains(name, "/") {
+			return nil, fmt.Errorf("%w: invalid file tree entry %q", ErrInvalidArgument, name)
+		}
+
+		children, err := metaFilesV2(dict, append(prefix[:len(prefix):len(prefix)], name), depth+1)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, children...)
+	}
+	// map iteration order is random
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].Path < files[j].Path
+	})
+	return files, nil
+}
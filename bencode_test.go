@@ -0,0 +1,132 @@
+package kahva
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// single file v1 torrent
+const testTorrentV1 = "d8:announce18:http://tracker/ann4:infod6:lengthi1024e4:name8:test.bin12:piece lengthi16384e6:pieces20:AAAAAAAAAAAAAAAAAAAAee"
+
+// v2 torrent with the files b and dir/a
+const testTorrentV2 = "d4:infod9:file treed3:dird1:ad0:d6:lengthi10e11:pieces root32:BBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBeee1:bd0:d6:lengthi5eeee12:meta versioni2e4:name3:dir12:piece lengthi16384eee"
+
+func TestDecodeBencode(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		want    interface{}
+		wantErr bool
+	}{
+		{"integer", "i42e", int64(42), false},
+		{"negative integer", "i-7e", int64(-7), false},
+		{"zero", "i0e", int64(0), false},
+		{"negative zero", "i-0e", nil, true},
+		{"leading zero", "i03e", nil, true},
+		{"empty integer", "ie", nil, true},
+		{"integer overflow", "i9223372036854775808e", nil, true},
+		{"unterminated integer", "i42", nil, true},
+		{"string", "4:spam", "spam", false},
+		{"empty string", "0:", "", false},
+		{"truncated string", "5:spam", nil, true},
+		{"string length with leading zero", "04:spam", nil, true},
+		{"missing string length separator", "4spam", nil, true},
+		{"list", "l4:spami1ee", []interface{}{"spam", int64(1)}, false},
+		{"unterminated list", "l4:spam", nil, true},
+		{"dictionary", "d3:cow3:mooe", map[string]interface{}{"cow": "moo"}, false},
+		{"unterminated dictionary", "d3:cow3:moo", nil, true},
+		{"dictionary without value", "d3:cowe", nil, true},
+		{"integer dictionary key", "di1e3:mooe", nil, true},
+		{"duplicate dictionary key", "d1:ai1e1:ai2ee", nil, true},
+		{"trailing data", "i1ei2e", nil, true},
+		{"empty input", "", nil, true},
+		{"unexpected byte", "x", nil, true},
+		{"nesting too deep", strings.Repeat("l", bencodeMaxDepth+2) + strings.Repeat("e", bencodeMaxDepth+2), nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, _, err := decodeBencode([]byte(tt.data))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("decodeBencode(%q) error = %v, want error %v", tt.data, err, tt.wantErr)
+			}
+			if err != nil && !errors.Is(err, errBencode) {
+				t.Fatalf("expected errBencode, got %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("decodeBencode(%q) = %#v, want %#v", tt.data, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseTorrent(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want TorrentMeta
+	}{
+		{"v1", testTorrentV1, TorrentMeta{
+			Hash:  "7DBDCBCFBDD306C058848B78BC2048822BB4CBA6",
+			Name:  "test.bin",
+			Size:  1024,
+			Files: []MetaFile{{Path: "test.bin", Size: 1024}},
+		}},
+		{"v2", testTorrentV2, TorrentMeta{
+			Hash:   "3070041C730BBC32C22171258CDF0198FB96812A",
+			HashV2: "c641d81a8ef01b8d456ddc413fd832ddb7890a0395d200fae2567c3ee9da4ed1",
+			Name:   "dir",
+			Size:   15,
+			Files:  []MetaFile{{Path: "b", Size: 5}, {Path: "dir/a", Size: 10}},
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseTorrent([]byte(tt.data), 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("got %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseTorrentInvalid(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		maxSize int64
+	}{
+		{"empty", "", 0},
+		{"truncated", testTorrentV1[:len(testTorrentV1)-10], 0},
+		{"trailing data", testTorrentV1 + "i1e", 0},
+		{"larger than maximum size", testTorrentV1, 10},
+		{"not a dictionary", "l4:infoe", 0},
+		{"no info", "d8:announce3:urle", 0},
+		{"info is a list", "d4:infoli1eee", 0},
+		{"info is a string", "d4:info4:spame", 0},
+		{"no name", "d4:infod6:lengthi1e12:piece lengthi1e6:pieces20:AAAAAAAAAAAAAAAAAAAAee", 0},
+		{"no piece length", "d4:infod6:lengthi1e4:name1:a6:pieces20:AAAAAAAAAAAAAAAAAAAAee", 0},
+		{"invalid pieces", "d4:infod6:lengthi1e4:name1:a12:piece lengthi1e6:pieces3:AAAee", 0},
+		{"negative length", "d4:infod6:lengthi-1e4:name1:a12:piece lengthi1e6:pieces20:AAAAAAAAAAAAAAAAAAAAee", 0},
+		{"no files", "d4:infod4:name1:a12:piece lengthi1eee", 0},
+		{"file path with parent directory", "d4:infod5:filesld6:lengthi1e4:pathl2:..eee4:name1:a12:piece lengthi1e6:pieces20:AAAAAAAAAAAAAAAAAAAAee", 0},
+		{"file path with slash", "d4:infod5:filesld6:lengthi1e4:pathl3:a/beee4:name1:a12:piece lengthi1e6:pieces20:AAAAAAAAAAAAAAAAAAAAee", 0},
+		{"v2 without file tree", "d4:infod12:meta versioni2e4:name1:a12:piece lengthi1eee", 0},
+		{"v2 file tree entry is not a dictionary", "d4:infod9:file treed1:ai1ee12:meta versioni2e4:name1:a12:piece lengthi1eee", 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseTorrent([]byte(tt.data), tt.maxSize)
+			if !errors.Is(err, ErrInvalidArgument) {
+				t.Fatalf("expected ErrInvalidArgument, got %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,408 @@
+package kahva
+
+import (
+	"context"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kolo/xmlrpc"
+)
+
+// CacheConfig contains the time to live of cached responses per call kind.
+// A zero TTL disables caching for the kind.
+type CacheConfig struct {
+	// d.multicall2
+	View time.Duration
+	// system.multicall of getters
+	System time.Duration
+	// f.multicall, p.multicall and t.multicall
+	Files time.Duration
+}
+
+type cacheKind int
+
+const (
+	cacheNone cacheKind = iota
+	cacheView
+	cacheSystem
+	cacheFiles
+)
+
+// Expired entries are removed when a response is stored, at most once per interval
+const cacheSweepInterval = 10 * time.Second
+
+var infoHashRx = regexp.MustCompile(`^[0-9A-Fa-f]{40}$`)
+
+// Methods that change the state of rTorrent in addition to setters and methods
+// with one of the mutatingPrefixes
+var mutatingMethods = map[string]bool{
+	"d.start":                  true,
+	"d.stop":                   true,
+	"d.pause":                  true,
+	"d.resume":                 true,
+	"d.open":                   true,
+	"d.close":                  true,
+	"d.close.directly":         true,
+	"d.try_start":              true,
+	"d.try_stop":               true,
+	"d.try_close":              true,
+	"d.erase":                  true,
+	"d.check_hash":             true,
+	"d.update_priorities":      true,
+	"d.tracker_announce":       true,
+	"d.tracker.insert":         true,
+	"d.tracker.send_scrape":    true,
+	"d.save_resume":            true,
+	"d.save_full_session":      true,
+	"d.create_link":            true,
+	"d.delete_link":            true,
+	"d.delete_tied":            true,
+	"d.disconnect.seeders":     true,
+	"d.views.push_back":        true,
+	"d.views.push_back_unique": true,
+	"d.views.remove":           true,
+	"p.disconnect":             true,
+	"p.disconnect_delayed":     true,
+	"t.enable":                 true,
+	"t.disable":                true,
+	"t.disable_if_dynamic":     true,
+	"view.add":                 true,
+	"view.filter":              true,
+	"view.filter_on":           true,
+	"view.sort":                true,
+	"view.sort_current":        true,
+	"view.sort_new":            true,
+	"method.insert":            true,
+	"method.erase":             true,
+	"method.redirect":          true,
+	"throttle.up":              true,
+	"throttle.down":            true,
+	"session.save":             true,
+	"system.shutdown":          true,
+	"system.shutdown.normal":   true,
+	"system.shutdown.quick":    true,
+	"schedule2":                true,
+	"schedule_remove2":         true,
+	"import":                   true,
+	"try_import":               true,
+}
+
+var mutatingPrefixes = []string{"load.", "execute."}
+
+// Methods with one of the mutatingPrefixes that do not invalidate the cache,
+// kahva only captures the output of commands that do not change anything like realpath
+var readOnlyMethods = map[string]bool{
+	"execute.capture":         true,
+	"execute.capture_nothrow": true,
+}
+
+type cacheEntry struct {
+	response xmlrpc.Response
+	kind     cacheKind
+	// torrent hash for per torrent calls
+	hash     string
+	expires  time.Time
+	modified time.Time
+}
+
+type cacheCall struct {
+	done     chan struct{}
+	response xmlrpc.Response
+	modified time.Time
+	err      error
+}
+
+// cache stores XMLRPC responses of read only calls and coalesces concurrent identical calls.
+// Entries are invalidated when a mutating call is made.
+type cache struct {
+	config CacheConfig
+
+	mu       sync.Mutex
+	entries  map[string]*cacheEntry
+	inflight map[string]*cacheCall
+	// next time expired entries are removed
+	sweep time.Time
+	// incremented on every invalidation so that in-flight calls
+	// started before a mutation are not stored
+	generation uint64
+}
+
+func newCache(config CacheConfig) *cache {
+	return &cache{
+		config:   config,
+		entries:  make(map[string]*cacheEntry),
+		inflight: make(map[string]*cacheCall),
+	}
+}
+
+func (c *cache) ttl(kind cacheKind) time.Duration {
+	switch kind {
+	case cacheView:
+		return c.config.View
+	case cacheSystem:
+		return c.config.System
+	case cacheFiles:
+		return c.config.Files
+	}
+	return 0
+}
+
+// Returns a cached response or calls fetch once for concurrent identical calls
+func (c *cache) get(ctx context.Context, kind cacheKind, key string, hash string, fetch func(context.Context) (xmlrpc.Response, error)) (xmlrpc.Response, time.Time, error) {
+	c.mu.Lock()
+	entry, ok := c.entries[key]
+	if ok && time.Now().Before(entry.expires) {
+		c.mu.Unlock()
+		return entry.response, entry.modified, nil
+	}
+
+	call, ok := c.inflight[key]
+	if ok {
+		c.mu.Unlock()
+		select {
+		case <-call.done:
+			return call.response, call.modified, call.err
+		case <-ctx.Done():
+			return nil, time.Time{}, &ConnectionError{Method: "cache", Err: ctx.Err()}
+		}
+	}
+
+	call = &cacheCall{done: make(chan struct{})}
+	c.inflight[key] = call
+	generation := c.generation
+	c.mu.Unlock()
+
+	// the call is shared with other callers, one of them going away must not cancel it
+	response, err := fetch(context.WithoutCancel(ctx))
+	now := time.Now()
+
+	c.mu.Lock()
+	delete(c.inflight, key)
+	modified := now
+	if err == nil {
+		// keep the modification time if the response did not change
+		previous, ok := c.entries[key]
+		if ok && string(previous.response) == string(response) {
+			modified = previous.modified
+		}
+		if generation == c.generation {
+			if now.After(c.sweep) {
+				c.removeExpired(now)
+			}
+			c.entries[key] = &cacheEntry{
+				response: response,
+				kind:     kind,
+				hash:     hash,
+				expires:  now.Add(c.ttl(kind)),
+				modified: modified,
+			}
+		}
+	}
+	c.mu.Unlock()
+
+	call.response, call.modified, call.err = response, modified, err
+	close(call.done)
+	return response, modified, err
+}
+
+// Removes expired entries, otherwise entries of torrents that are not requested
+// again stay forever. Must be called with the lock held.
+func (c *cache) removeExpired(now time.Time) {
+	for key, entry := range c.entries {
+		if !now.Before(entry.expires) {
+			delete(c.entries, key)
+		}
+	}
+	c.sweep = now.Add(cacheSweepInterval)
+}
+
+// Removes entries affected by a mutating call. Calls that touch a torrent invalidate
+// the views and the entries of that torrent, other calls invalidate everything.
+func (c *cache) invalidate(hashes []string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.generation++
+	if len(hashes) == 0 {
+		clear(c.entries)
+		return
+	}
+
+	touched := make(map[string]bool, len(hashes))
+	for _, hash := range hashes {
+		touched[strings.ToUpper(hash)] = true
+	}
+	for key, entry := range c.entries {
+		if entry.kind == cacheView || touched[strings.ToUpper(entry.hash)] {
+			delete(c.entries, key)
+		}
+	}
+}
+
+// Returns the cache kind of a call and the torrent hash for per torrent calls.
+// Calls with kind cacheNone are not cached.
+func cacheKindOf(method string, args []interface{}) (cacheKind, string) {
+	if isMutatingCall(method, args) {
+		return cacheNone, ""
+	}
+
+	switch method {
+	case "d.multicall2":
+		return cacheView, ""
+	case "f.multicall", "p.multicall", "t.multicall":
+		if len(args) > 0 {
+			hash, _ := args[0].(string)
+			return cacheFiles, hash
+		}
+	case "system.multicall":
+		if len(args) == 0 {
+			return cacheNone, ""
+		}
+		calls, ok := args[0].([]interface{})
+		if !ok {
+			return cacheNone, ""
+		}
+		for _, call := range calls {
+			sc, ok := call.(SystemCall)
+			if !ok || !isGetter(sc.MethodName) {
+				return cacheNone, ""
+			}
+		}
+		return cacheSystem, ""
+	case "system.listMethods":
+		return cacheSystem, ""
+	}
+	return cacheNone, ""
+}
+
+// Returns true for system and throttle getters
+func isGetter(method string) bool {
+	if !strings.HasPrefix(method, "system.") && !strings.HasPrefix(method, "throttle.") {
+		return false
+	}
+	return !isMutating(method)
+}
+
+// Returns true if the method changes the state of rTorrent
+func isMutating(method string) bool {
+	method = strings.TrimSuffix(method, "=")
+	if readOnlyMethods[method] {
+		return false
+	}
+	if strings.Contains(method, ".set") || mutatingMethods[method] {
+		return true
+	}
+	for _, prefix := range mutatingPrefixes {
+		if strings.HasPrefix(method, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns true if a call changes the state of rTorrent, including mutating
+// calls in a system.multicall and mutating commands of a multicall
+func isMutatingCall(method string, args []interface{}) bool {
+	if isMutating(method) {
+		return true
+	}
+
+	switch method {
+	case "d.multicall2", "f.multicall", "p.multicall", "t.multicall":
+		// the commands follow the hash or view and a pattern
+		for i := 2; i < len(args); i++ {
+			command, ok := args[i].(string)
+			if ok && isMutating(command) {
+				return true
+			}
+		}
+	case "system.multicall":
+		if len(args) == 0 {
+			return false
+		}
+		calls, ok := args[0].([]interface{})
+		if !ok {
+			// unknown shapes are treated as mutating
+			return true
+		}
+		for _, call := range calls {
+			sc, ok := call.(SystemCall)
+			if !ok || isMutating(sc.MethodName) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Returns the info-hash of a call target, targets of files, peers and trackers
+// of a torrent (e.g. HASH:f0, HASH:p<id> or HASH:t1) start with the hash
+func targetHash(target string) (string, bool) {
+	hash, _, _ := strings.Cut(target, ":")
+	return hash, infoHashRx.MatchString(hash)
+}
+
+// Returns info-hashes found in the arguments of a call, including the
+// arguments of calls nested in a system.multicall
+func callHashes(args []interface{}) []string {
+	hashes := make([]string, 0)
+	for _, arg := range args {
+		switch v := arg.(type) {
+		case string:
+			if hash, ok := targetHash(v); ok {
+				hashes = append(hashes, hash)
+			}
+		case []interface{}:
+			hashes = append(hashes, callHashes(v)...)
+		case []string:
+			for _, s := range v {
+				if hash, ok := targetHash(s); ok {
+					hashes = append(hashes, hash)
+				}
+			}
+		case SystemCall:
+			switch params := v.Params.(type) {
+			case []interface{}:
+				hashes = append(hashes, callHashes(params)...)
+			case []string:
+				hashes = append(hashes, callHashes([]interface{}{params})...)
+			}
+		}
+	}
+	return hashes
+}
+
+type cacheInfoKey struct{}
+
+// cacheInfo collects the latest modification time of cached responses used to serve a request
+type cacheInfo struct {
+	mu       sync.Mutex
+	modified time.Time
+}
+
+// Returns a context which records modification times of cached responses
+func withCacheInfo(ctx context.Context) (context.Context, *cacheInfo) {
+	info := &cacheInfo{}
+	return context.WithValue(ctx, cacheInfoKey{}, info), info
+}
+
+func recordModified(ctx context.Context, modified time.Time) {
+	info, ok := ctx.Value(cacheInfoKey{}).(*cacheInfo)
+	if !ok {
+		return
+	}
+	info.mu.Lock()
+	defer info.mu.Unlock()
+	if modified.After(info.modified) {
+		info.modified = modified
+	}
+}
+
+// Returns the latest modification time, zero if no cached response was used
+func (i *cacheInfo) Modified() time.Time {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	return i.modified
+}
@@ -1,8 +1,12 @@
 package main
 
 import (
+	"context"
 	"net/http"
 	"os"
+	"regexp"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gorilla/mux"
@@ -28,10 +32,44 @@ func main() {
 		)
 	}
 
+	// default timeout for a single xmlrpc call, shorter than the server write timeout
+	// so that a hung call is answered with a timeout error
+	timeout, err := durationEnv("XMLRPC_TIMEOUT", 4*time.Second)
+	if err != nil {
+		log.Fatal().Err(err).Msgf("unable to parse XMLRPC_TIMEOUT")
+		return
+	}
+
+	// enable response cache if any ttl is set
+	var cache *kahva.CacheConfig
+	if os.Getenv("CACHE_VIEW_TTL") != "" || os.Getenv("CACHE_SYSTEM_TTL") != "" || os.Getenv("CACHE_FILES_TTL") != "" {
+		cache = &kahva.CacheConfig{}
+		for env, ttl := range map[string]*time.Duration{
+			"CACHE_VIEW_TTL":   &cache.View,
+			"CACHE_SYSTEM_TTL": &cache.System,
+			"CACHE_FILES_TTL":  &cache.Files,
+		} {
+			*ttl, err = durationEnv(env, 0)
+			if err != nil {
+				log.Fatal().Err(err).Msgf("unable to parse %s", env)
+				return
+			}
+		}
+	}
+
+	// an empty delimiter disables multiple labels per torrent
+	labelDelimiter, ok := os.LookupEnv("LABEL_DELIMITER")
+	if !ok {
+		labelDelimiter = kahva.DefaultLabelDelimiter
+	}
+
 	rtorrent, err := kahva.NewRtorrent(
 		kahva.Config{
-			URL:       os.Getenv("XMLRPC_URL"),
-			Transport: transport,
+			URL:            os.Getenv("XMLRPC_URL"),
+			Transport:      transport,
+			Timeout:        timeout,
+			Cache:          cache,
+			LabelDelimiter: labelDelimiter,
 		},
 	)
 	if err != nil {
@@ -40,30 +78,242 @@ func main() {
 	}
 	defer rtorrent.Close()
 
-	fs := http.FileServer(http.Dir("./www"))
+	// poll interval for the events stream
+	interval, err := durationEnv("EVENTS_INTERVAL", 2*time.Second)
+	if err != nil || interval <= 0 {
+		log.Fatal().Err(err).Msgf("unable to parse EVENTS_INTERVAL")
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	events := kahva.NewEvents(rtorrent, "main", interval)
+	go events.Run(ctx)
+
+	// ban peers with matching client versions if the regex is set
+	if os.Getenv("PEER_BAN_CLIENT") != "" {
+		client, err := regexp.Compile(os.Getenv("PEER_BAN_CLIENT"))
+		if err != nil {
+			log.Fatal().Err(err).Msgf("unable to parse PEER_BAN_CLIENT")
+			return
+		}
+		banInterval, err := durationEnv("PEER_BAN_INTERVAL", 30*time.Second)
+		if err != nil || banInterval <= 0 {
+			log.Fatal().Err(err).Msgf("unable to parse PEER_BAN_INTERVAL")
+			return
+		}
+		go kahva.NewPeerBanner(rtorrent, client, banInterval).Run(ctx)
+	}
+
+	load := kahva.DefaultLoadConfig
+	for env, limit := range map[string]*int64{
+		"LOAD_MAX_REQUEST_SIZE": &load.MaxRequestSize,
+		"LOAD_MAX_TORRENT_SIZE": &load.MaxTorrentSize,
+	} {
+		if os.Getenv(env) == "" {
+			continue
+		}
+		*limit, err = strconv.ParseInt(os.Getenv(env), 10, 64)
+		if err != nil || *limit <= 0 {
+			log.Fatal().Err(err).Msgf("unable to parse %s", env)
+			return
+		}
+	}
+	load.FetchTimeout, err = durationEnv("LOAD_FETCH_TIMEOUT", load.FetchTimeout)
+	if err != nil || load.FetchTimeout <= 0 {
+		log.Fatal().Err(err).Msgf("unable to parse LOAD_FETCH_TIMEOUT")
+		return
+	}
+	// private trackers on the local network have to be allowed explicitly
+	load.FetchPrivate = os.Getenv("LOAD_FETCH_PRIVATE") == "true"
+	if os.Getenv("LOAD_PARALLELISM") != "" {
+		load.Parallelism, err = strconv.Atoi(os.Getenv("LOAD_PARALLELISM"))
+		if err != nil || load.Parallelism <= 0 {
+			log.Fatal().Err(err).Msgf("unable to parse LOAD_PARALLELISM")
+			return
+		}
+	}
+
+	// data of erased torrents can only be deleted inside these roots
+	erase := kahva.EraseConfig{Mode: kahva.EraseModeLocal}
+	if os.Getenv("ERASE_MODE") != "" {
+		erase.Mode = os.Getenv("ERASE_MODE")
+	}
+	if erase.Mode != kahva.EraseModeLocal && erase.Mode != kahva.EraseModeExecute {
+		log.Fatal().Msgf("ERASE_MODE must be %s or %s", kahva.EraseModeLocal, kahva.EraseModeExecute)
+		return
+	}
+	erase.Roots = rootsEnv("ERASE_ROOTS")
+
+	// data can only be moved between these roots, defaults to the erase roots
+	move := kahva.MoveConfig{Roots: erase.Roots}
+	if os.Getenv("MOVE_ROOTS") != "" {
+		move.Roots = rootsEnv("MOVE_ROOTS")
+	}
+	jobs := kahva.NewMoveJobs(ctx, rtorrent, move)
+
+	// enable authentication of api clients if the users file is set
+	var auth *kahva.Auth
+	if os.Getenv("AUTH_USERS_FILE") != "" {
+		config := kahva.AuthConfig{
+			UsersFile:    os.Getenv("AUTH_USERS_FILE"),
+			TokensFile:   os.Getenv("AUTH_TOKENS_FILE"),
+			SecureCookie: os.Getenv("AUTH_SECURE_COOKIE") == "true",
+		}
+		// the frontend may be served from the cors origin
+		if origin := os.Getenv("CORS_ORIGIN"); origin != "" && origin != "*" {
+			config.Origins = []string{origin}
+		}
+		config.SessionTTL, err = durationEnv("AUTH_SESSION_TTL", 24*time.Hour)
+		if err != nil || config.SessionTTL <= 0 {
+			log.Fatal().Err(err).Msgf("unable to parse AUTH_SESSION_TTL")
+			return
+		}
+		auth, err = kahva.NewAuth(config)
+		if err != nil {
+			log.Fatal().Err(err).Msgf("unable to read AUTH_USERS_FILE")
+			return
+		}
+	} else {
+		log.Warn().Msg("AUTH_USERS_FILE is not set, the api is not authenticated")
+	}
+
+	var audit *kahva.AuditLog
+	if os.Getenv("AUDIT_FILE") != "" {
+		config := kahva.DefaultAuditConfig
+		config.File = os.Getenv("AUDIT_FILE")
+		if os.Getenv("AUDIT_MAX_SIZE") != "" {
+			config.MaxSize, err = strconv.ParseInt(os.Getenv("AUDIT_MAX_SIZE"), 10, 64)
+			if err != nil || config.MaxSize <= 0 {
+				log.Fatal().Err(err).Msgf("unable to parse AUDIT_MAX_SIZE")
+				return
+			}
+		}
+		if os.Getenv("AUDIT_MAX_BACKUPS") != "" {
+			config.MaxBackups, err = strconv.Atoi(os.Getenv("AUDIT_MAX_BACKUPS"))
+			if err != nil || config.MaxBackups < 0 {
+				log.Fatal().Err(err).Msgf("unable to parse AUDIT_MAX_BACKUPS")
+				return
+			}
+		}
+		audit, err = kahva.NewAuditLog(rtorrent, config)
+		if err != nil {
+			log.Fatal().Err(err).Msgf("unable to open AUDIT_FILE")
+			return
+		}
+		defer audit.Close()
+	}
+
+	var fs http.Handler = http.FileServer(http.Dir("./www"))
+	if auth != nil && os.Getenv("AUTH_STATIC") == "true" {
+		fs = auth.StaticMiddleware(fs)
+	}
 
 	r := mux.NewRouter()
 	r.Handle("/", fs)
 	r.PathPrefix("/assets/").Handler(fs)
 
-	s := r.PathPrefix("/api").Subrouter()
-	s.HandleFunc("/view/{view}", kahva.ViewHandler(rtorrent))
-	s.HandleFunc("/system", kahva.SystemHandler(rtorrent))
-	s.HandleFunc("/load", kahva.LoadHandler(rtorrent)).Methods("POST")
-	// todo: use post body instead of action fragment
-	s.HandleFunc("/torrent/{hash}/{action}", kahva.TorrentHandler(rtorrent)).Methods("GET", "POST")
-	s.HandleFunc("/throttle", kahva.ThrottleHandler(rtorrent)).Methods("POST")
-	s.Use(kahva.CORSMiddleware)
+	// login is the only api route that does not require authentication
+	if auth != nil {
+		r.Handle("/api/v1/auth/login", kahva.CORSMiddleware(kahva.LoginHandler(auth))).Methods("POST")
+		r.Handle("/api/auth/login", kahva.DeprecatedMiddleware("/api/v1")(kahva.CORSMiddleware(kahva.LoginHandler(auth)))).Methods("POST")
+	}
+
+	// roles are only enforced when the api is authenticated
+	readOnly := func(h http.Handler) http.Handler { return kahva.RequireRole(kahva.RoleReadOnly, h) }
+	operator := func(h http.Handler) http.Handler { return kahva.RequireRole(kahva.RoleOperator, h) }
+	admin := func(h http.Handler) http.Handler { return kahva.RequireRole(kahva.RoleAdmin, h) }
+	scope := kahva.ScopeMiddleware(rtorrent)
+
+	// routes which are the same in every api version
+	shared := func(s *mux.Router) {
+		if auth != nil {
+			s.HandleFunc("/auth/logout", kahva.LogoutHandler(auth)).Methods("POST")
+			s.HandleFunc("/auth/me", kahva.MeHandler()).Methods("GET")
+			s.Handle("/auth/tokens", readOnly(kahva.TokensHandler(auth))).Methods("GET", "POST")
+			s.Handle("/auth/tokens/{name}", readOnly(kahva.TokenDeleteHandler(auth))).Methods("DELETE")
+		}
+		s.Handle("/events", readOnly(kahva.EventsHandler(events))).Methods("GET")
+		s.Handle("/system", readOnly(kahva.SystemHandler(rtorrent))).Methods("GET")
+		s.Handle("/labels", readOnly(kahva.LabelsHandler(rtorrent))).Methods("GET")
+		s.Handle("/labels", operator(kahva.LabelActionsHandler(rtorrent))).Methods("POST")
+		s.Handle("/labels/rename", operator(kahva.LabelRenameHandler(rtorrent))).Methods("POST")
+		s.Handle("/jobs/{id}", readOnly(kahva.MoveJobHandler(rtorrent, jobs))).Methods("GET")
+		if audit != nil {
+			s.Handle("/audit", admin(kahva.AuditHandler(audit))).Methods("GET")
+		}
+		// bulk actions check the role of the action
+		s.Handle("/torrents/actions", operator(kahva.ActionsHandler(rtorrent))).Methods("POST")
+		s.Handle("/trackers/replace", admin(kahva.TrackerReplaceHandler(rtorrent))).Methods("POST")
+		s.Handle("/throttle", admin(kahva.ThrottleHandler(rtorrent))).Methods("POST")
+
+		s.NotFoundHandler = kahva.RouteErrorHandler(s)
+		s.MethodNotAllowedHandler = kahva.RouteErrorHandler(s)
+		s.Use(kahva.CORSMiddleware)
+		if auth != nil {
+			s.Use(auth.Middleware)
+		}
+		// runs after authentication to record the user
+		if audit != nil {
+			s.Use(audit.Middleware)
+		}
+	}
+
+	v1 := r.PathPrefix("/api/v1").Subrouter()
+	v1.Handle("/torrents", readOnly(kahva.ViewHandler(rtorrent))).Methods("GET")
+	v1.Handle("/torrents", operator(kahva.LoadHandler(rtorrent, load))).Methods("POST").Name("load")
+	shared(v1)
+
+	// users restricted to labels can only access torrents with one of them
+	torrent := v1.PathPrefix("/torrents/{hash:[0-9A-Fa-f]{40}}").Subrouter()
+	torrent.Handle("", readOnly(kahva.TorrentDetailsHandler(rtorrent))).Methods("GET")
+	torrent.Handle("", operator(kahva.TorrentUpdateHandler(rtorrent))).Methods("PATCH").Name("update")
+	torrent.Handle("", admin(kahva.EraseHandler(rtorrent, erase))).Methods("DELETE").Name("erase")
+	torrent.Handle("/{action:start|stop|pause|resume|hash}", operator(kahva.TorrentActionHandler(rtorrent))).Methods("POST")
+	torrent.Handle("/files", readOnly(kahva.TorrentFilesHandler(rtorrent))).Methods("GET")
+	torrent.Handle("/files/priority", operator(kahva.FilePriorityHandler(rtorrent))).Methods("POST")
+	torrent.Handle("/peers", readOnly(kahva.TorrentPeersHandler(rtorrent))).Methods("GET")
+	torrent.Handle("/peers", operator(kahva.PeersHandler(rtorrent))).Methods("POST")
+	torrent.Handle("/trackers", readOnly(kahva.TorrentTrackersHandler(rtorrent))).Methods("GET")
+	torrent.Handle("/trackers", operator(kahva.TrackersHandler(rtorrent))).Methods("POST")
+	torrent.Handle("/move", admin(kahva.MoveHandler(jobs))).Methods("POST")
+	torrent.Use(scope)
+
+	// deprecated routes replaced by /api/v1, actions that change a torrent are not accepted over GET
+	r.Handle("/api/torrent/{hash}/{action:start|stop|pause|resume|hash|erase|priority}",
+		kahva.DeprecatedMiddleware("/api/v1")(kahva.CORSMiddleware(kahva.UnsafeGetHandler()))).Methods("GET", "HEAD")
+	legacy := r.PathPrefix("/api").Subrouter()
+	legacy.Handle("/view/{view}", readOnly(kahva.ViewHandler(rtorrent)))
+	legacy.Handle("/load", operator(kahva.LoadHandler(rtorrent, load))).Methods("POST")
+	shared(legacy)
+	legacy.Use(kahva.DeprecatedMiddleware("/api/v1"))
+
+	legacyTorrent := legacy.PathPrefix("/torrent/{hash}").Subrouter()
+	legacyTorrent.Handle("/files/priority", operator(kahva.FilePriorityHandler(rtorrent))).Methods("POST")
+	legacyTorrent.Handle("/peers", operator(kahva.PeersHandler(rtorrent))).Methods("POST")
+	legacyTorrent.Handle("/trackers", operator(kahva.TrackersHandler(rtorrent))).Methods("POST")
+	legacyTorrent.Handle("/move", admin(kahva.MoveHandler(jobs))).Methods("POST")
+	legacyTorrent.Handle("/erase", admin(kahva.EraseHandler(rtorrent, erase))).Methods("POST")
+	legacyTorrent.Handle("/{action}", kahva.RequireActionRole(kahva.TorrentActionRoles, kahva.TorrentHandler(rtorrent))).Methods("GET", "POST")
+	legacyTorrent.Use(scope)
 
 	address := os.Getenv("SERVER_ADDRESS")
 	if address == "" {
 		address = "0.0.0.0:8080"
 	}
 
+	// the write deadline starts when the request has been read, leave time to
+	// respond after the xmlrpc timeout expired
+	writeTimeout := 5 * time.Second
+	if timeout+time.Second > writeTimeout {
+		writeTimeout = timeout + time.Second
+	}
+
 	srv := &http.Server{
 		ReadHeaderTimeout: 5 * time.Second,
 		ReadTimeout:       5 * time.Second,
-		WriteTimeout:      5 * time.Second,
+		WriteTimeout:      writeTimeout,
 		Addr:              address,
 		Handler:           r,
 	}
@@ -76,6 +326,28 @@ func main() {
 	}
 }
 
+// Parses a duration from an environment variable, returns fallback if the variable is empty
+func durationEnv(name string, fallback time.Duration) (time.Duration, error) {
+	value := os.Getenv(name)
+	if value == "" {
+		return fallback, nil
+	}
+	return time.ParseDuration(value)
+}
+
+// Parses a comma separated list of remote[:local] download roots from an environment variable
+func rootsEnv(name string) []kahva.EraseRoot {
+	roots := make([]kahva.EraseRoot, 0)
+	for _, root := range strings.Split(os.Getenv(name), ",") {
+		if root == "" {
+			continue
+		}
+		remote, local, _ := strings.Cut(root, ":")
+		roots = append(roots, kahva.EraseRoot{Remote: remote, Local: local})
+	}
+	return roots
+}
+
 type basicAuthTransport struct {
 	Username string
 	Password string
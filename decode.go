@@ -0,0 +1,169 @@
+package kahva
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+)
+
+// FieldError describes a single value in a XMLRPC response which could not be decoded
+type FieldError struct {
+	// Row in a multicall result, zero for system multicalls
+	Row     int
+	Command string
+	Err     error
+}
+
+func (e FieldError) Error() string {
+	return fmt.Sprintf("row %d: %s: %s", e.Row, e.Command, e.Err)
+}
+
+// FieldErrors is a list of values which could not be decoded.
+// Values that decoded successfully are still set on the result.
+type FieldErrors []FieldError
+
+func (e FieldErrors) Error() string {
+	msgs := make([]string, 0, len(e))
+	for _, fe := range e {
+		msgs = append(msgs, fe.Error())
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// Returns the error of decoding a response of method. FieldErrors are logged and dropped so that
+// a single unexpected value does not fail the whole call, the decoded values are used instead.
+// Errors in the shape of the response are returned as a DecodeError.
+func decodeError(method string, err error) error {
+	if err == nil {
+		return nil
+	}
+	var fieldErrs FieldErrors
+	if errors.As(err, &fieldErrs) {
+		log.Warn().Err(err).Str("method", method).Msg("cant decode some values of the response")
+		return nil
+	}
+	return &DecodeError{Method: method, Err: err}
+}
+
+// Returns indices of struct fields keyed by their rt tag
+func tagFields(t reflect.Type) map[string][]int {
+	fields := make(map[string][]int)
+	for i := 0; i < t.NumField(); i++ {
+		tag := t.Field(i).Tag.Get("rt")
+		if tag == "" || !t.Field(i).IsExported() {
+			continue
+		}
+		fields[tag] = append(fields[tag], i)
+	}
+	return fields
+}
+
+// Sets dst to the XMLRPC value v converting between compatible types.
+// nil values leave dst untouched.
+func decodeValue(dst reflect.Value, v interface{}) error {
+	if v == nil {
+		return nil
+	}
+
+	switch dst.Kind() {
+	case reflect.String:
+		switch x := v.(type) {
+		case string:
+			dst.SetString(x)
+		case int64:
+			dst.SetString(strconv.FormatInt(x, 10))
+		case float64:
+			dst.SetString(strconv.FormatFloat(x, 'f', -1, 64))
+		case bool:
+			dst.SetString(strconv.FormatBool(x))
+		default:
+			return fmt.Errorf("cant decode %T into string", v)
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := toInt64(v)
+		if err != nil {
+			return err
+		}
+		if dst.OverflowInt(n) {
+			return fmt.Errorf("value %d overflows %s", n, dst.Type())
+		}
+		dst.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := toInt64(v)
+		if err != nil {
+			return err
+		}
+		if n < 0 || dst.OverflowUint(uint64(n)) {
+			return fmt.Errorf("value %d overflows %s", n, dst.Type())
+		}
+		dst.SetUint(uint64(n))
+	case reflect.Float32, reflect.Float64:
+		switch x := v.(type) {
+		case float64:
+			dst.SetFloat(x)
+		case int64:
+			dst.SetFloat(float64(x))
+		case string:
+			f, err := strconv.ParseFloat(strings.TrimSpace(x), 64)
+			if err != nil {
+				return fmt.Errorf("cant decode string %q into %s", x, dst.Type())
+			}
+			dst.SetFloat(f)
+		default:
+			return fmt.Errorf("cant decode %T into %s", v, dst.Type())
+		}
+	case reflect.Bool:
+		switch x := v.(type) {
+		case bool:
+			dst.SetBool(x)
+		default:
+			n, err := toInt64(v)
+			if err != nil {
+				return err
+			}
+			dst.SetBool(n != 0)
+		}
+	case reflect.Interface:
+		if dst.NumMethod() != 0 {
+			return fmt.Errorf("cant decode into %s", dst.Type())
+		}
+		dst.Set(reflect.ValueOf(v))
+	default:
+		rv := reflect.ValueOf(v)
+		if !rv.Type().AssignableTo(dst.Type()) {
+			return fmt.Errorf("cant decode %T into %s", v, dst.Type())
+		}
+		dst.Set(rv)
+	}
+	return nil
+}
+
+// Converts integer compatible XMLRPC values to int64
+func toInt64(v interface{}) (int64, error) {
+	switch x := v.(type) {
+	case int64:
+		return x, nil
+	case bool:
+		if x {
+			return 1, nil
+		}
+		return 0, nil
+	case float64:
+		if x != math.Trunc(x) || x < math.MinInt64 || x >= math.MaxInt64 {
+			return 0, fmt.Errorf("cant decode float %v into int64", x)
+		}
+		return int64(x), nil
+	case string:
+		n, err := strconv.ParseInt(strings.TrimSpace(x), 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("cant decode string %q into int64", x)
+		}
+		return n, nil
+	}
+	return 0, fmt.Errorf("cant decode %T into int64", v)
+}
@@ -0,0 +1,277 @@
+package kahva
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type decodeTarget struct {
+	Int     int64       `rt:"int"`
+	Int8    int8        `rt:"int8"`
+	Uint8   uint8       `rt:"uint8"`
+	String  string      `rt:"string"`
+	Bool    bool        `rt:"bool"`
+	Float   float64     `rt:"float"`
+	Float32 float32     `rt:"float32"`
+	Any     interface{} `rt:"any"`
+}
+
+var decodeCommands = []string{"int", "int8", "uint8", "string", "bool", "float", "float32", "any"}
+
+func TestDecodeValue(t *testing.T) {
+	tests := []struct {
+		name    string
+		field   string
+		value   interface{}
+		want    interface{}
+		wantErr bool
+	}{
+		{"int64", "Int", int64(42), int64(42), false},
+		{"string into int64", "Int", " 42 ", int64(42), false},
+		{"bool into int64", "Int", true, int64(1), false},
+		{"whole float into int64", "Int", float64(3), int64(3), false},
+		{"fractional float into int64", "Int", 3.5, int64(0), true},
+		{"huge float into int64", "Int", math.MaxFloat64, int64(0), true},
+		{"invalid string into int64", "Int", "x", int64(0), true},
+		{"nil keeps zero value", "Int", nil, int64(0), false},
+		{"int8", "Int8", int64(-128), int8(-128), false},
+		{"int8 overflow", "Int8", int64(128), int8(0), true},
+		{"uint8", "Uint8", int64(255), uint8(255), false},
+		{"uint8 overflow", "Uint8", int64(256), uint8(0), true},
+		{"negative uint8", "Uint8", int64(-1), uint8(0), true},
+		{"string", "String", "name", "name", false},
+		{"int64 into string", "String", int64(-7), "-7", false},
+		{"float into string", "String", 1.5, "1.5", false},
+		{"bool into string", "String", false, "false", false},
+		{"array into string", "String", []interface{}{}, "", true},
+		{"bool", "Bool", true, true, false},
+		{"int64 into bool", "Bool", int64(2), true, false},
+		{"string into bool", "Bool", "0", false, false},
+		{"float", "Float", 0.25, 0.25, false},
+		{"int64 into float", "Float", int64(3), float64(3), false},
+		{"string into float", "Float", "2.5", 2.5, false},
+		{"invalid string into float", "Float", "x", float64(0), true},
+		{"float32", "Float32", 0.5, float32(0.5), false},
+		{"bool into float", "Float", true, float64(0), true},
+		{"any", "Any", "value", "value", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var target decodeTarget
+			field := reflect.ValueOf(&target).Elem().FieldByName(tt.field)
+			err := decodeValue(field, tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("decodeValue(%#v) error = %v, want error %v", tt.value, err, tt.wantErr)
+			}
+			if got := field.Interface(); !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("decodeValue(%#v) = %#v, want %#v", tt.value, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMulticallTags(t *testing.T) {
+	result := []interface{}{
+		[]interface{}{int64(1), int64(2), int64(3), "a", int64(1), 1.5, int64(2), "x"},
+		// rows with missing or invalid values keep the values that could be decoded
+		[]interface{}{int64(4), int64(300)},
+		"not a row",
+	}
+
+	items, err := multicallTags[decodeTarget](result, decodeCommands)
+	var errs FieldErrors
+	if !errors.As(err, &errs) {
+		t.Fatalf("expected FieldErrors, got %v", err)
+	}
+	if len(items) != 3 {
+		t.Fatalf("expected 3 items, got %d", len(items))
+	}
+	want := decodeTarget{Int: 1, Int8: 2, Uint8: 3, String: "a", Bool: true, Float: 1.5, Float32: 2, Any: "x"}
+	if !reflect.DeepEqual(items[0], want) {
+		t.Fatalf("got %#v, want %#v", items[0], want)
+	}
+	if items[1].Int != 4 || items[1].Int8 != 0 {
+		t.Fatalf("got %#v", items[1])
+	}
+	// int8 overflow and 6 missing values of the second row, the third row is not an array
+	if len(errs) != 8 {
+		t.Fatalf("expected 8 field errors, got %d: %v", len(errs), errs)
+	}
+
+	_, err = multicallTags[decodeTarget]("not an array", decodeCommands)
+	if err == nil {
+		t.Fatal("expected an error for a non array result")
+	}
+}
+
+func TestSystemTags(t *testing.T) {
+	args := []interface{}{[]interface{}{
+		SystemCall{MethodName: "system.hostname", Params: []interface{}{""}},
+		SystemCall{MethodName: "system.pid", Params: []interface{}{""}},
+		SystemCall{MethodName: "system.time_seconds", Params: []interface{}{""}},
+	}}
+	result := []interface{}{
+		[]interface{}{"host"},
+		[]interface{}{"1234"},
+		map[string]interface{}{"faultCode": int64(-501), "faultString": "fault"},
+	}
+
+	system, err := systemTags(result, args)
+	var errs FieldErrors
+	if !errors.As(err, &errs) || len(errs) != 1 || errs[0].Command != "system.time_seconds" {
+		t.Fatalf("expected a field error for the fault, got %v", err)
+	}
+	if system.Hostname != "host" || system.PID != 1234 {
+		t.Fatalf("got %#v", system)
+	}
+}
+
+func TestDecodeError(t *testing.T) {
+	// values that can not be decoded do not fail the call
+	_, err := multicallTags[decodeTarget]([]interface{}{[]interface{}{"x"}}, decodeCommands)
+	if err == nil || decodeError("d.multicall2", err) != nil {
+		t.Fatalf("expected field errors to be dropped, got %v", err)
+	}
+
+	_, err = multicallTags[decodeTarget]("not an array", decodeCommands)
+	var decodeErr *DecodeError
+	if !errors.As(decodeError("d.multicall2", err), &decodeErr) {
+		t.Fatalf("expected a DecodeError, got %v", err)
+	}
+	if decodeError("d.multicall2", nil) != nil {
+		t.Fatal("expected nil")
+	}
+}
+
+// Builds an arbitrary tree of XMLRPC values from fuzzer input
+type treeReader struct {
+	data  []byte
+	depth int
+}
+
+func (r *treeReader) byte() byte {
+	if len(r.data) == 0 {
+		return 0
+	}
+	b := r.data[0]
+	r.data = r.data[1:]
+	return b
+}
+
+func (r *treeReader) bytes(n int) []byte {
+	if n > len(r.data) {
+		n = len(r.data)
+	}
+	b := r.data[:n]
+	r.data = r.data[n:]
+	return b
+}
+
+func (r *treeReader) uint64() uint64 {
+	b := make([]byte, 8)
+	copy(b, r.bytes(8))
+	return binary.LittleEndian.Uint64(b)
+}
+
+func (r *treeReader) value() interface{} {
+	switch r.byte() % 10 {
+	case 0:
+		return nil
+	case 1:
+		return int64(r.uint64())
+	case 2:
+		return math.Float64frombits(r.uint64())
+	case 3:
+		return r.byte()%2 == 1
+	case 4:
+		return string(r.bytes(int(r.byte() % 32)))
+	case 5:
+		return r.bytes(int(r.byte() % 32))
+	case 6:
+		return time.Unix(int64(r.uint64()%(1<<40)), 0)
+	case 7:
+		m := make(map[string]interface{})
+		if r.depth < 4 {
+			r.depth++
+			for n := int(r.byte() % 4); n > 0; n-- {
+				m[string(r.bytes(4))] = r.value()
+			}
+			r.depth--
+		}
+		return m
+	default:
+		values := make([]interface{}, 0)
+		if r.depth < 4 {
+			r.depth++
+			for n := int(r.byte() % 12); n > 0; n-- {
+				values = append(values, r.value())
+			}
+			r.depth--
+		}
+		return values
+	}
+}
+
+func fuzzSeeds(f *testing.F) {
+	f.Add([]byte{})
+	f.Add([]byte{8, 2, 8, 3, 1, 42, 0, 0, 0, 0, 0, 0, 0, 4, 3, 'a', 'b', 'c'})
+	f.Add([]byte{8, 11, 8, 8, 1, 255, 255, 255, 255, 255, 255, 255, 127, 2, 0, 0, 0, 0, 0, 0, 240, 127})
+	f.Add([]byte{9, 3, 7, 2, 'k', 'e', 'y', 's', 0, 5, 4, 1, 2, 3, 4, 6, 1, 2, 3, 4, 5, 6, 7, 8})
+}
+
+func FuzzMulticallTags(f *testing.F) {
+	fuzzSeeds(f)
+	commands := append(append([]string{}, decodeCommands...), MulticallCommands[Torrent]()...)
+	f.Fuzz(func(t *testing.T, data []byte) {
+		result := (&treeReader{data: data}).value()
+		items, err := multicallTags[decodeTarget](result, commands)
+		if rows, ok := result.([]interface{}); ok && len(items) != len(rows) {
+			t.Fatalf("expected %d items, got %d (err %v)", len(rows), len(items), err)
+		}
+		multicallTags[Torrent](result, MulticallCommands[Torrent]())
+		multicallTags[File](result, MulticallCommands[File]())
+	})
+}
+
+func FuzzSystemTags(f *testing.F) {
+	fuzzSeeds(f)
+	fields := tagFields(reflect.TypeOf(System{}))
+	calls := make([]interface{}, 0, len(fields))
+	for method := range fields {
+		calls = append(calls, SystemCall{MethodName: method, Params: []interface{}{""}})
+	}
+	args := []interface{}{calls}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		r := &treeReader{data: data}
+		systemTags(r.value(), args)
+		// arbitrary args must not panic either
+		systemTags(r.value(), r.value())
+	})
+}
+
+func FuzzParseTorrent(f *testing.F) {
+	f.Add([]byte(testTorrentV1))
+	f.Add([]byte(testTorrentV2))
+	f.Add([]byte("d4:infod5:filesld6:lengthi1e4:pathl1:aeee4:name1:a12:piece lengthi1e6:pieces20:AAAAAAAAAAAAAAAAAAAAee"))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		meta, err := ParseTorrent(data, DefaultMaxTorrentSize)
+		if err != nil {
+			return
+		}
+		if len(meta.Hash) != 40 || meta.Name == "" {
+			t.Fatalf("invalid metadata %#v", meta)
+		}
+		var size int64
+		for _, file := range meta.Files {
+			size += file.Size
+		}
+		if size != meta.Size {
+			t.Fatalf("size %d does not match the files %d", meta.Size, size)
+		}
+	})
+}
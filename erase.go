@@ -0,0 +1,380 @@
+package kahva
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Maximum number of paths passed to a single command run through rTorrent
+const executeChunkSize = 100
+
+// ErrPathNotAllowed is returned when data outside the configured download roots would be deleted
+var ErrPathNotAllowed = errors.New("path not allowed")
+
+// Erase modes
+const (
+	// Data is deleted by kahva from a locally mounted path
+	EraseModeLocal = "local"
+	// Data is deleted by rTorrent using execute.throw
+	EraseModeExecute = "execute"
+)
+
+// EraseRoot is a download directory data may be deleted from
+type EraseRoot struct {
+	// Path as seen by rTorrent
+	Remote string
+	// Path as seen by kahva in local mode, same as Remote if empty
+	Local string
+}
+
+// EraseConfig controls deleting torrent data. Data is never deleted if there are no roots.
+type EraseConfig struct {
+	Mode  string
+	Roots []EraseRoot
+}
+
+type eraseFile struct {
+	Path       string `rt:"f.path="`
+	FrozenPath string `rt:"f.frozen_path="`
+}
+
+// Returns the absolute paths of the data of a torrent as seen by rTorrent and the
+// torrent directory for multi file torrents which is removed once it is empty
+func erasePaths(ctx context.Context, rt *Rtorrent, hash string) ([]string, string, error) {
+	var directory string
+	err := rt.call(ctx, "d.directory", hash, &directory)
+	if err != nil {
+		return nil, "", err
+	}
+
+	var multiFile int64
+	err = rt.call(ctx, "d.is_multi_file", hash, &multiFile)
+	if err != nil {
+		return nil, "", err
+	}
+
+	files, err := Multicall[eraseFile](ctx, rt, "f.multicall", hash, "")
+	if err != nil {
+		return nil, "", err
+	}
+
+	if !filepath.IsAbs(directory) {
+		return nil, "", fmt.Errorf("%w: torrent directory %q is not absolute", ErrPathNotAllowed, directory)
+	}
+
+	paths := make([]string, 0, len(files))
+	for _, f := range files {
+		path := f.FrozenPath
+		if path == "" {
+			path = filepath.Join(directory, f.Path)
+		}
+		paths = append(paths, filepath.Clean(path))
+	}
+
+	dir := ""
+	if multiFile != 0 {
+		dir = filepath.Clean(directory)
+	}
+	return paths, dir, nil
+}
+
+// Returns the root containing path, paths equal to a root are not allowed
+func (c EraseConfig) root(path string) (EraseRoot, error) {
+	for _, root := range c.Roots {
+		remote := filepath.Clean(root.Remote)
+		rel, err := filepath.Rel(remote, path)
+		if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, "../") {
+			continue
+		}
+		return root, nil
+	}
+	return EraseRoot{}, fmt.Errorf("%w: %q is outside the download roots", ErrPathNotAllowed, path)
+}
+
+// Maps a path as seen by rTorrent to the local path
+func (c EraseConfig) local(path string) (string, error) {
+	root, err := c.root(path)
+	if err != nil {
+		return "", err
+	}
+	if root.Local == "" {
+		return path, nil
+	}
+	rel, _ := filepath.Rel(filepath.Clean(root.Remote), path)
+	return filepath.Join(root.Local, rel), nil
+}
+
+// Checks that every path is inside a download root. In local mode symlinks are resolved
+// so that a link inside a root can not be used to delete data outside of it, in execute
+// mode checkRemote resolves them through rTorrent.
+func (c EraseConfig) check(paths []string) error {
+	if len(c.Roots) == 0 {
+		return fmt.Errorf("%w: no download roots are configured", ErrPathNotAllowed)
+	}
+
+	for _, path := range paths {
+		if !filepath.IsAbs(path) {
+			return fmt.Errorf("%w: %q is not absolute", ErrPathNotAllowed, path)
+		}
+		root, err := c.root(path)
+		if err != nil {
+			return err
+		}
+		if c.Mode != EraseModeLocal {
+			continue
+		}
+
+		local, err := c.local(path)
+		if err != nil {
+			return err
+		}
+		resolved, err := evalExisting(filepath.Dir(local))
+		if err != nil {
+			return err
+		}
+		base := root.Local
+		if base == "" {
+			base = root.Remote
+		}
+		resolvedRoot, err := filepath.EvalSymlinks(base)
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(resolvedRoot, resolved)
+		if err != nil || rel == ".." || strings.HasPrefix(rel, "../") {
+			return fmt.Errorf("%w: %q resolves outside the download roots", ErrPathNotAllowed, path)
+		}
+	}
+	return nil
+}
+
+// Resolves symlinks in the deepest existing ancestor of path. Components below it do not
+// exist and can not be symlinks, but directories created for them would be created
+// wherever the ancestor points to.
+func evalExisting(path string) (string, error) {
+	missing := make([]string, 0)
+	for {
+		resolved, err := filepath.EvalSymlinks(path)
+		if err == nil {
+			return filepath.Join(append([]string{resolved}, missing...)...), nil
+		}
+		parent := filepath.Dir(path)
+		if !errors.Is(err, fs.ErrNotExist) || parent == path {
+			return "", err
+		}
+		missing = append([]string{filepath.Base(path)}, missing...)
+		path = parent
+	}
+}
+
+// Resolves symlinks in the parent directories of paths through rTorrent and checks that
+// they are still inside the download root of the path. Paths are resolved with realpath -m,
+// components that do not exist can not be symlinks.
+func (c EraseConfig) checkRemote(ctx context.Context, rt *Rtorrent, paths []string) error {
+	// each path is resolved against the resolved root it is in
+	parents := make(map[string]string)
+	for _, path := range paths {
+		root, err := c.root(path)
+		if err != nil {
+			return err
+		}
+		parents[filepath.Dir(path)] = filepath.Clean(root.Remote)
+	}
+
+	unresolved := make([]string, 0, len(parents)+len(c.Roots))
+	for parent := range parents {
+		unresolved = append(unresolved, parent)
+	}
+	for _, root := range c.Roots {
+		unresolved = append(unresolved, filepath.Clean(root.Remote))
+	}
+	resolved, err := realpaths(ctx, rt, unresolved)
+	if err != nil {
+		return err
+	}
+
+	for parent, root := range parents {
+		rel, err := filepath.Rel(resolved[root], resolved[parent])
+		if err != nil || rel == ".." || strings.HasPrefix(rel, "../") {
+			return fmt.Errorf("%w: %q resolves outside the download roots", ErrPathNotAllowed, parent)
+		}
+	}
+	return nil
+}
+
+// Returns the canonical paths of paths as seen by rTorrent keyed by path
+func realpaths(ctx context.Context, rt *Rtorrent, paths []string) (map[string]string, error) {
+	resolved := make(map[string]string, len(paths))
+	for _, chunk := range pathChunks(paths) {
+		args := []interface{}{"", "realpath", "-m", "--"}
+		for _, path := range chunk {
+			// the output has one path per line
+			if strings.Contains(path, "\n") {
+				return nil, fmt.Errorf("%w: %q contains a newline", ErrPathNotAllowed, path)
+			}
+			args = append(args, path)
+		}
+
+		var output string
+		err := rt.call(ctx, "execute.capture", args, &output)
+		if err != nil {
+			return nil, err
+		}
+		lines := strings.Split(strings.TrimSuffix(output, "\n"), "\n")
+		if len(lines) != len(chunk) {
+			return nil, fmt.Errorf("%w: cant resolve paths, expected %d paths, got %d", ErrPathNotAllowed, len(chunk), len(lines))
+		}
+		for j, line := range lines {
+			if !filepath.IsAbs(line) {
+				return nil, fmt.Errorf("%w: cant resolve %q", ErrPathNotAllowed, chunk[j])
+			}
+			resolved[chunk[j]] = filepath.Clean(line)
+		}
+	}
+	return resolved, nil
+}
+
+// Splits paths into chunks so that the argument list of a single command stays reasonably small
+func pathChunks(paths []string) [][]string {
+	chunks := make([][]string, 0, (len(paths)+executeChunkSize-1)/executeChunkSize)
+	for i := 0; i < len(paths); i += executeChunkSize {
+		chunks = append(chunks, paths[i:min(i+executeChunkSize, len(paths))])
+	}
+	return chunks
+}
+
+// Deletes files and then the directories below dir that became empty.
+// Returns the deleted paths as seen by rTorrent.
+func (c EraseConfig) removeLocal(paths []string, dir string) ([]string, error) {
+	removed := make([]string, 0, len(paths))
+	dirs := make(map[string]bool)
+	for _, path := range paths {
+		local, err := c.local(path)
+		if err != nil {
+			return removed, err
+		}
+		err = os.Remove(local)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return removed, err
+		}
+		if err == nil {
+			removed = append(removed, path)
+		}
+
+		if dir == "" {
+			continue
+		}
+		for parent := filepath.Dir(path); parent == dir || strings.HasPrefix(parent, dir+"/"); parent = filepath.Dir(parent) {
+			dirs[parent] = true
+			if parent == dir {
+				break
+			}
+		}
+	}
+
+	// deepest directories first, directories which are not empty are kept
+	ordered := make([]string, 0, len(dirs))
+	for d := range dirs {
+		ordered = append(ordered, d)
+	}
+	sort.Slice(ordered, func(i, j int) bool {
+		return len(ordered[i]) > len(ordered[j])
+	})
+	for _, d := range ordered {
+		local, err := c.local(d)
+		if err != nil {
+			continue
+		}
+		if os.Remove(local) == nil {
+			removed = append(removed, d)
+		}
+	}
+	return removed, nil
+}
+
+// Deletes files and empty directories through rTorrent. Returns the paths passed to rm
+// and dir if it was removed.
+func (c EraseConfig) removeExecute(ctx context.Context, rt *Rtorrent, paths []string, dir string) ([]string, error) {
+	removed := make([]string, 0, len(paths)+1)
+	for _, chunk := range pathChunks(paths) {
+		args := []interface{}{"", "rm", "-f", "--"}
+		for _, path := range chunk {
+			args = append(args, path)
+		}
+		err := rt.call(ctx, "execute.throw", args, nil)
+		if err != nil {
+			return removed, err
+		}
+		removed = append(removed, chunk...)
+	}
+
+	if dir == "" {
+		return removed, nil
+	}
+	err := rt.call(ctx, "execute.nothrow", []interface{}{"", "find", dir, "-depth", "-type", "d", "-empty", "-delete"}, nil)
+	if err != nil {
+		return removed, err
+	}
+	// directories with other files or files that could not be deleted are kept
+	var output string
+	err = rt.call(ctx, "execute.capture", []interface{}{"", "sh", "-c", `if [ -e "$1" ]; then echo exists; fi`, "sh", dir}, &output)
+	if err != nil {
+		return removed, err
+	}
+	if strings.TrimSpace(output) == "" {
+		removed = append(removed, dir)
+	}
+	return removed, nil
+}
+
+// Erases a torrent and deletes its data. With dryRun nothing is changed and the paths
+// that would be deleted are returned.
+func EraseWithData(ctx context.Context, rt *Rtorrent, config EraseConfig, hash string, dryRun bool) ([]string, error) {
+	if config.Mode != EraseModeLocal && config.Mode != EraseModeExecute {
+		return nil, fmt.Errorf("unknown erase mode %q", config.Mode)
+	}
+
+	paths, dir, err := erasePaths(ctx, rt, hash)
+	if err != nil {
+		return nil, err
+	}
+
+	check := append([]string{}, paths...)
+	if dir != "" {
+		check = append(check, dir)
+	}
+	err = config.check(check)
+	if err != nil {
+		return nil, err
+	}
+	if config.Mode == EraseModeExecute {
+		err = config.checkRemote(ctx, rt, check)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if dryRun {
+		if dir != "" {
+			return append(paths, dir), nil
+		}
+		return paths, nil
+	}
+
+	// erase first so that rTorrent closes the files
+	err = rt.EraseContext(ctx, hash)
+	if err != nil {
+		return nil, err
+	}
+
+	if config.Mode == EraseModeLocal {
+		return config.removeLocal(paths, dir)
+	}
+	return config.removeExecute(ctx, rt, paths, dir)
+}
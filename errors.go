@@ -0,0 +1,124 @@
+package kahva
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Machine readable error codes returned in ErrorResponse
+const (
+	ErrorCodeBadRequest          = "bad_request"
+	ErrorCodeNotFound            = "not_found"
+	ErrorCodeUnauthorized        = "unauthorized"
+	ErrorCodeForbidden           = "forbidden"
+	ErrorCodeMethodNotAllowed    = "method_not_allowed"
+	ErrorCodeRtorrentFault       = "rtorrent_fault"
+	ErrorCodeRtorrentUnavailable = "rtorrent_unavailable"
+	ErrorCodeRtorrentTimeout     = "rtorrent_timeout"
+	ErrorCodeDecode              = "decode_error"
+	ErrorCodeInternal            = "internal_error"
+)
+
+// ErrInvalidArgument is returned when the client rejects an argument before calling rTorrent
+var ErrInvalidArgument = errors.New("invalid argument")
+
+// ErrNotFound is returned when something kahva keeps track of does not exist
+var ErrNotFound = errors.New("not found")
+
+// FaultError is returned when rTorrent responds to a call with a XMLRPC fault
+type FaultError struct {
+	Method string
+	Code   int
+	String string
+}
+
+func (e *FaultError) Error() string {
+	return fmt.Sprintf("%s: fault %d: %s", e.Method, e.Code, e.String)
+}
+
+// Returns true if rTorrent could not find the torrent or view the call refers to,
+// e.g. "Could not find info-hash." or "Could not find view: foo"
+func (e *FaultError) NotFound() bool {
+	return strings.HasPrefix(e.String, "Could not find")
+}
+
+// ConnectionError is returned when rTorrent can not be reached or the
+// transport (e.g. nginx) responds with a non-successful status code
+type ConnectionError struct {
+	Method string
+	// HTTP status code returned by the transport, zero if no response was received
+	StatusCode int
+	Err        error
+}
+
+func (e *ConnectionError) Error() string {
+	if e.StatusCode != 0 {
+		return fmt.Sprintf("%s: bad status code %d", e.Method, e.StatusCode)
+	}
+	return fmt.Sprintf("%s: %s", e.Method, e.Err)
+}
+
+func (e *ConnectionError) Unwrap() error {
+	return e.Err
+}
+
+// Returns true if the call was aborted because of a timeout
+func (e *ConnectionError) Timeout() bool {
+	return errors.Is(e.Err, context.DeadlineExceeded)
+}
+
+// DecodeError is returned when the XMLRPC response does not have the expected shape
+type DecodeError struct {
+	Method string
+	Err    error
+}
+
+func (e *DecodeError) Error() string {
+	return fmt.Sprintf("%s: cant decode response: %s", e.Method, e.Err)
+}
+
+func (e *DecodeError) Unwrap() error {
+	return e.Err
+}
+
+// Returns true if err is a fault caused by an unknown info-hash or view
+func IsNotFound(err error) bool {
+	var fault *FaultError
+	return errors.As(err, &fault) && fault.NotFound()
+}
+
+// Maps an error to a HTTP status code and a machine readable error code
+func errorStatus(err error) (int, string) {
+	var (
+		fault  *FaultError
+		conn   *ConnectionError
+		decode *DecodeError
+	)
+
+	switch {
+	case errors.Is(err, ErrInvalidArgument):
+		return http.StatusBadRequest, ErrorCodeBadRequest
+	case errors.Is(err, ErrNotFound):
+		return http.StatusNotFound, ErrorCodeNotFound
+	case errors.Is(err, ErrUnauthorized):
+		return http.StatusUnauthorized, ErrorCodeUnauthorized
+	case errors.Is(err, ErrPathNotAllowed), errors.Is(err, ErrForbidden):
+		return http.StatusForbidden, ErrorCodeForbidden
+	case errors.As(err, &fault):
+		if fault.NotFound() {
+			return http.StatusNotFound, ErrorCodeNotFound
+		}
+		return http.StatusBadGateway, ErrorCodeRtorrentFault
+	case errors.As(err, &conn):
+		if conn.Timeout() {
+			return http.StatusGatewayTimeout, ErrorCodeRtorrentTimeout
+		}
+		return http.StatusBadGateway, ErrorCodeRtorrentUnavailable
+	case errors.As(err, &decode):
+		return http.StatusInternalServerError, ErrorCodeDecode
+	}
+	return http.StatusInternalServerError, ErrorCodeInternal
+}
@@ -0,0 +1,232 @@
+package kahva
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Events polls a view in the background and broadcasts changes to subscribers.
+// rTorrent is polled once per interval regardless of the number of subscribers
+// and not at all when there are none. Subscribers only receive torrents in their scope.
+type Events struct {
+	rt       *Rtorrent
+	view     string
+	interval time.Duration
+
+	mu sync.Mutex
+	// scope of each subscriber
+	subscribers map[chan []byte][]string
+	snapshot    []Torrent
+	// snapshot keyed by hash, nil until the first successful poll
+	byHash map[string]Torrent
+}
+
+// Creates a new instance of Events for the view
+func NewEvents(rt *Rtorrent, view string, interval time.Duration) *Events {
+	return &Events{
+		rt:          rt,
+		view:        view,
+		interval:    interval,
+		subscribers: make(map[chan []byte][]string),
+	}
+}
+
+// Polls rTorrent until the context is cancelled
+func (e *Events) Run(ctx context.Context) {
+	ticker := time.NewTicker(e.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			e.poll(ctx)
+		}
+	}
+}
+
+func (e *Events) poll(ctx context.Context) {
+	e.mu.Lock()
+	idle := len(e.subscribers) == 0
+	if idle {
+		// forget the snapshot so that it is not stale once someone subscribes again
+		e.snapshot = nil
+		e.byHash = nil
+	}
+	e.mu.Unlock()
+	if idle {
+		return
+	}
+
+	torrents, err := Multicall[Torrent](ctx, e.rt, "d.multicall2", "", e.view)
+	if err != nil {
+		log.Error().Err(err).Msg("cant poll view for events")
+		return
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	current := make(map[string]Torrent, len(torrents))
+	for _, t := range torrents {
+		current[t.Hash] = t
+	}
+
+	previous := e.byHash
+	e.snapshot = torrents
+	e.byHash = current
+	e.broadcast(previous)
+}
+
+// Sends the changes since previous to all subscribers, a nil previous sends a snapshot.
+// Subscribers that can not keep up are dropped and expected to reconnect. Must be called with the lock held.
+func (e *Events) broadcast(previous map[string]Torrent) {
+	// events are encoded once per scope
+	messages := make(map[string][]byte)
+	for ch, scope := range e.subscribers {
+		key := strings.Join(scope, "\n")
+		msg, ok := messages[key]
+		if !ok {
+			msg = e.event(previous, scope)
+			messages[key] = msg
+		}
+		if msg == nil {
+			continue
+		}
+
+		select {
+		case ch <- msg:
+		default:
+			delete(e.subscribers, ch)
+			close(ch)
+		}
+	}
+}
+
+// Returns the encoded event with the torrents in the scope, nil if none of them changed
+func (e *Events) event(previous map[string]Torrent, scope []string) []byte {
+	torrents := filterScope(e.snapshot, scope, e.rt.labelDelimiter)
+
+	var msg []byte
+	var err error
+	if previous == nil {
+		msg, err = encodeEvent("snapshot", EventSnapshot{Torrents: torrents})
+	} else {
+		// torrents whose labels move them in or out of the scope are added or removed
+		before := make(map[string]Torrent, len(previous))
+		for hash, t := range previous {
+			if inScope(t.Custom1, scope, e.rt.labelDelimiter) {
+				before[hash] = t
+			}
+		}
+		after := make(map[string]Torrent, len(torrents))
+		for _, t := range torrents {
+			after[t.Hash] = t
+		}
+
+		delta := diffTorrents(before, after, torrents)
+		if len(delta.Added) == 0 && len(delta.Removed) == 0 && len(delta.Changed) == 0 {
+			return nil
+		}
+		msg, err = encodeEvent("delta", delta)
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("cant encode event")
+		return nil
+	}
+	return msg
+}
+
+// Registers a subscriber for the torrents in the scope, an empty scope receives every torrent.
+// The returned channel receives encoded events starting with the current snapshot if one
+// exists and is closed when the subscriber is dropped.
+func (e *Events) Subscribe(scope []string) chan []byte {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	ch := make(chan []byte, 16)
+	if e.byHash != nil {
+		msg := e.event(nil, scope)
+		if msg != nil {
+			ch <- msg
+		}
+	}
+	e.subscribers[ch] = scope
+	return ch
+}
+
+// Removes a subscriber
+func (e *Events) Unsubscribe(ch chan []byte) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if _, ok := e.subscribers[ch]; ok {
+		delete(e.subscribers, ch)
+		close(ch)
+	}
+}
+
+// Encodes an event in the Server-Sent Events format
+func encodeEvent(event string, data any) ([]byte, error) {
+	b, err := json.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+	msg := make([]byte, 0, len(b)+len(event)+16)
+	msg = append(msg, "event: "...)
+	msg = append(msg, event...)
+	msg = append(msg, "\ndata: "...)
+	msg = append(msg, b...)
+	msg = append(msg, "\n\n"...)
+	return msg, nil
+}
+
+// Returns torrents added to and removed from current compared to previous,
+// and the changed fields of torrents present in both
+func diffTorrents(previous, current map[string]Torrent, order []Torrent) EventDelta {
+	delta := EventDelta{
+		Added:   make([]Torrent, 0),
+		Removed: make([]string, 0),
+		Changed: make([]map[string]interface{}, 0),
+	}
+
+	t := reflect.TypeOf(Torrent{})
+	for _, torrent := range order {
+		prev, ok := previous[torrent.Hash]
+		if !ok {
+			delta.Added = append(delta.Added, torrent)
+			continue
+		}
+
+		a := reflect.ValueOf(prev)
+		b := reflect.ValueOf(torrent)
+		var changed map[string]interface{}
+		for i := 0; i < t.NumField(); i++ {
+			name := jsonName(t.Field(i))
+			if name == "" || a.Field(i).Interface() == b.Field(i).Interface() {
+				continue
+			}
+			if changed == nil {
+				changed = map[string]interface{}{"hash": torrent.Hash}
+			}
+			changed[name] = b.Field(i).Interface()
+		}
+		if changed != nil {
+			delta.Changed = append(delta.Changed, changed)
+		}
+	}
+
+	for hash := range previous {
+		if _, ok := current[hash]; !ok {
+			delta.Removed = append(delta.Removed, hash)
+		}
+	}
+	return delta
+}
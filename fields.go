@@ -0,0 +1,88 @@
+package kahva
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// Splits comma separated and repeated field query parameters into a list of field names
+func parseFields(values []string) []string {
+	fields := make([]string, 0)
+	seen := make(map[string]bool)
+	for _, value := range values {
+		for _, field := range strings.Split(value, ",") {
+			field = strings.TrimSpace(field)
+			if field == "" || seen[field] {
+				continue
+			}
+			seen[field] = true
+			fields = append(fields, field)
+		}
+	}
+	return fields
+}
+
+// Returns the JSON name of a struct field, empty if the field is not serialized
+func jsonName(field reflect.StructField) string {
+	name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+	if name == "-" || !field.IsExported() {
+		return ""
+	}
+	if name == "" {
+		return field.Name
+	}
+	return name
+}
+
+// Resolves JSON field names of T to the multicall commands in their rt tags.
+// Unknown field names are rejected with ErrInvalidArgument.
+func fieldCommands[T any](names []string) ([]string, error) {
+	t := reflect.TypeOf(*new(T))
+	byName := make(map[string]string)
+	for i := 0; i < t.NumField(); i++ {
+		name := jsonName(t.Field(i))
+		tag := t.Field(i).Tag.Get("rt")
+		if name == "" || tag == "" {
+			continue
+		}
+		byName[name] = tag
+	}
+
+	commands := make([]string, 0, len(names))
+	for _, name := range names {
+		command, ok := byName[name]
+		if !ok {
+			return nil, fmt.Errorf("%w: unknown field %q", ErrInvalidArgument, name)
+		}
+		commands = append(commands, command)
+	}
+	return commands, nil
+}
+
+// Returns items as maps containing only the requested JSON fields
+func projectFields[T any](items []T, names []string) []map[string]interface{} {
+	t := reflect.TypeOf(*new(T))
+	index := make(map[string]int)
+	for i := 0; i < t.NumField(); i++ {
+		name := jsonName(t.Field(i))
+		if name != "" {
+			index[name] = i
+		}
+	}
+
+	projected := make([]map[string]interface{}, 0, len(items))
+	for _, item := range items {
+		v := reflect.ValueOf(item)
+		m := make(map[string]interface{}, len(names))
+		for _, name := range names {
+			i, ok := index[name]
+			if !ok {
+				continue
+			}
+			m[name] = v.Field(i).Interface()
+		}
+		projected = append(projected, m)
+	}
+	return projected
+}
@@ -0,0 +1,89 @@
+package kahva
+
+import (
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// Returns the indexes of files selected by index ranges (e.g. "3" or "0-5") and
+// glob patterns. Patterns without a slash are matched against the file name,
+// other patterns against the path within the torrent.
+func selectFiles(files []File, ranges []string, patterns []string) (map[int]bool, error) {
+	selected := make(map[int]bool)
+	for _, r := range ranges {
+		first, last, found := strings.Cut(strings.TrimSpace(r), "-")
+		start, err := strconv.Atoi(first)
+		if err != nil {
+			return nil, fmt.Errorf("%w: invalid file index %q", ErrInvalidArgument, r)
+		}
+		end := start
+		if found {
+			end, err = strconv.Atoi(last)
+			if err != nil {
+				return nil, fmt.Errorf("%w: invalid file index range %q", ErrInvalidArgument, r)
+			}
+		}
+		if start < 0 || end < start || end >= len(files) {
+			return nil, fmt.Errorf("%w: file index %q out of range, torrent has %d files", ErrInvalidArgument, r, len(files))
+		}
+		for i := start; i <= end; i++ {
+			selected[i] = true
+		}
+	}
+
+	for _, pattern := range patterns {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("%w: invalid pattern %q", ErrInvalidArgument, pattern)
+		}
+		for i, f := range files {
+			name := f.Path
+			if !strings.Contains(pattern, "/") {
+				name = path.Base(f.Path)
+			}
+			if ok, _ := path.Match(pattern, name); ok {
+				selected[i] = true
+			}
+		}
+	}
+	return selected, nil
+}
+
+// Returns the new priority of each file that changes. With only set the files that
+// are not selected are turned off.
+func filePriorities(files []File, req FilePriorityRequest) (map[int]int, error) {
+	priority := FilePriorityNormal
+	if req.Priority != nil {
+		priority = *req.Priority
+	}
+	if priority < FilePriorityOff || priority > FilePriorityHigh {
+		return nil, fmt.Errorf("%w: file priority must be between 0 and 2", ErrInvalidArgument)
+	}
+	if len(req.Indexes) == 0 && len(req.Patterns) == 0 {
+		return nil, fmt.Errorf("%w: indexes or patterns are required", ErrInvalidArgument)
+	}
+
+	selected, err := selectFiles(files, req.Indexes, req.Patterns)
+	if err != nil {
+		return nil, err
+	}
+	if len(selected) == 0 {
+		return nil, fmt.Errorf("%w: no files matched", ErrInvalidArgument)
+	}
+
+	priorities := make(map[int]int)
+	for i, f := range files {
+		p := int(f.Priority)
+		switch {
+		case selected[i]:
+			p = priority
+		case req.Only:
+			p = FilePriorityOff
+		}
+		if p != int(f.Priority) {
+			priorities[i] = p
+		}
+	}
+	return priorities, nil
+}
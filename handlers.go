@@ -1,10 +1,14 @@
 package kahva
 
 import (
-	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
 	"encoding/json"
-	"io"
+	"errors"
+	"mime"
 	"net/http"
+	"strings"
+	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/rs/zerolog/log"
@@ -22,41 +26,155 @@ func respond(anything any, statusCode int, w http.ResponseWriter) {
 	w.Write(bytes)
 }
 
+// Responds with JSON and ETag and Last-Modified validators. Conditional requests
+// matching the validators are answered with 304 Not Modified.
+func respondCached(anything any, w http.ResponseWriter, r *http.Request, modified time.Time) {
+	bytes, err := json.Marshal(anything)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	sum := sha1.Sum(bytes)
+	etag := `"` + hex.EncodeToString(sum[:]) + `"`
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", "no-cache")
+	if !modified.IsZero() {
+		w.Header().Set("Last-Modified", modified.UTC().Format(http.TimeFormat))
+	}
+
+	if match := r.Header.Get("If-None-Match"); match != "" {
+		if match == etag || match == "*" {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+	} else if since := r.Header.Get("If-Modified-Since"); since != "" && !modified.IsZero() {
+		t, err := http.ParseTime(since)
+		if err == nil && !modified.Truncate(time.Second).After(t) {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(bytes)
+}
+
+// Responds with an ErrorResponse, status code and error code are derived from the error type
+func respondError(err error, w http.ResponseWriter) {
+	statusCode, code := errorStatus(err)
+	respond(ErrorResponse{
+		Status:  "error",
+		Code:    code,
+		Message: err.Error(),
+	}, statusCode, w)
+}
+
+// Responds to requests without a matching route of the router. Requests whose path matches
+// a route with other methods are answered with 405 Method Not Allowed, the routes are checked
+// here because mux does not report method mismatches of routes in subrouters reliably.
+func RouteErrorHandler(router *mux.Router) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		allowed := make([]string, 0)
+		router.Walk(func(route *mux.Route, _ *mux.Router, _ []*mux.Route) error {
+			methods, err := route.GetMethods()
+			if err != nil {
+				return nil
+			}
+			for _, method := range methods {
+				req := r.Clone(r.Context())
+				req.Method = method
+				if route.Match(req, &mux.RouteMatch{}) {
+					allowed = append(allowed, method)
+				}
+			}
+			return nil
+		})
+
+		if len(allowed) == 0 {
+			respond(ErrorResponse{
+				Status:  "error",
+				Code:    ErrorCodeNotFound,
+				Message: "no route for " + r.URL.Path,
+			}, http.StatusNotFound, w)
+			return
+		}
+
+		w.Header().Set("Allow", strings.Join(allowed, ", "))
+		respond(ErrorResponse{
+			Status:  "error",
+			Code:    ErrorCodeMethodNotAllowed,
+			Message: r.Method + " is not allowed for " + r.URL.Path,
+		}, http.StatusMethodNotAllowed, w)
+	}
+}
+
 func ViewHandler(rt *Rtorrent) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		vars := mux.Vars(r)
+		ctx, info := withCacheInfo(r.Context())
 
-		args := []interface{}{
-			"", vars["view"],
-			"d.hash=", "d.name=",
-			"d.size_bytes=", "d.completed_bytes=",
-			"d.up.rate=", "d.up.total=", "d.down.rate=",
-			"d.down.total=", "d.message=", "d.base_filename=",
-			"d.base_path=", "d.is_active=", "d.is_open=",
-			"d.is_hash_checking=", "d.peers_accounted=", "d.peers_complete=",
-			"d.state=", "d.state_changed=", "d.state_counter=", "d.priority=",
-			"d.custom1=", "d.custom2=", "d.custom3=",
-			"d.custom4=", "d.custom5="}
-
-		torrents, err := rt.DMulticall("main", args)
+		// the view is a route variable or a query parameter
+		view := mux.Vars(r)["view"]
+		if view == "" {
+			view = r.URL.Query().Get("view")
+		}
+		if view == "" {
+			view = "main"
+		}
+
+		query, err := ParseViewQuery(r.URL.Query())
+		if err != nil {
+			respondError(err, w)
+			return
+		}
+		query.LabelDelimiter = rt.labelDelimiter
+		query.Scope = scopeFromContext(ctx)
+
+		fields := parseFields(r.URL.Query()["fields"])
+		if len(fields) > 0 {
+			// fields used for filtering and sorting are fetched but not returned
+			commands, err := fieldCommands[Torrent](parseFields(append(fields, query.Fields()...)))
+			if err != nil {
+				respondError(err, w)
+				return
+			}
+
+			torrents, err := multicall[Torrent](ctx, rt, "d.multicall2", []interface{}{"", view}, commands)
+			if err != nil {
+				log.Error().Err(err).Msgf("cant fetch view")
+				respondError(err, w)
+				return
+			}
+			torrents, total := query.Apply(torrents)
+
+			respondCached(ViewFieldsResponse{
+				Status:   "ok",
+				Total:    total,
+				Torrents: projectFields(torrents, fields),
+			}, w, r, info.Modified())
+			return
+		}
+
+		torrents, err := Multicall[Torrent](ctx, rt, "d.multicall2", "", view)
 		if err != nil {
 			log.Error().Err(err).Msgf("cant fetch view")
-			respond(ErrorResponse{
-				Status:  "error",
-				Message: err.Error(),
-			}, http.StatusBadRequest, w)
+			respondError(err, w)
 			return
 		}
+		torrents, total := query.Apply(torrents)
 
-		respond(ViewResponse{
+		respondCached(ViewResponse{
 			Status:   "ok",
+			Total:    total,
 			Torrents: torrents,
-		}, http.StatusOK, w)
+		}, w, r, info.Modified())
 	}
 }
 
 func SystemHandler(rt *Rtorrent) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, info := withCacheInfo(r.Context())
 		args := []interface{}{
 			[]interface{}{
 				SystemCall{
@@ -110,19 +228,16 @@ func SystemHandler(rt *Rtorrent) http.HandlerFunc {
 			},
 		}
 
-		result, err := rt.SystemMulticall(args)
+		result, err := rt.SystemMulticallContext(ctx, args)
 		if err != nil {
 			log.Error().Err(err).Msgf("cant fetch system")
-			respond(ErrorResponse{
-				Status:  "error",
-				Message: err.Error(),
-			}, http.StatusInternalServerError, w)
+			respondError(err, w)
 			return
 		}
-		respond(SystemResponse{
+		respondCached(SystemResponse{
 			Status: "ok",
 			System: result,
-		}, http.StatusOK, w)
+		}, w, r, info.Modified())
 	}
 }
 
@@ -135,6 +250,7 @@ func ThrottleHandler(rt *Rtorrent) http.HandlerFunc {
 			log.Error().Err(err).Msg("cant decode throttle request json")
 			respond(ErrorResponse{
 				Status:  "error",
+				Code:    ErrorCodeBadRequest,
 				Message: err.Error(),
 			}, http.StatusBadRequest, w)
 			return
@@ -143,31 +259,26 @@ func ThrottleHandler(rt *Rtorrent) http.HandlerFunc {
 		if req.Type != "up" && req.Type != "down" {
 			respond(ErrorResponse{
 				Status:  "error",
+				Code:    ErrorCodeBadRequest,
 				Message: "type must be up or down",
 			}, http.StatusBadRequest, w)
 			return
 		}
 
 		if req.Type == "up" {
-			err := rt.GlobalThrottleUp(req.Kilobytes)
+			err := rt.GlobalThrottleUpContext(r.Context(), req.Kilobytes)
 			if err != nil {
 				log.Error().Err(err).Msg("cant set global up throttle")
-				respond(ErrorResponse{
-					Status:  "error",
-					Message: err.Error(),
-				}, http.StatusBadRequest, w)
+				respondError(err, w)
 				return
 			}
 		}
 
 		if req.Type == "down" {
-			err := rt.GlobalThrottleDown(req.Kilobytes)
+			err := rt.GlobalThrottleDownContext(r.Context(), req.Kilobytes)
 			if err != nil {
 				log.Error().Err(err).Msg("cant set global down throttle")
-				respond(ErrorResponse{
-					Status:  "error",
-					Message: err.Error(),
-				}, http.StatusBadRequest, w)
+				respondError(err, w)
 				return
 			}
 		}
@@ -179,247 +290,1114 @@ func ThrottleHandler(rt *Rtorrent) http.HandlerFunc {
 	}
 }
 
-func LoadHandler(rt *Rtorrent) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		r.ParseMultipartForm(10 << 20)
-
-		file, _, err := r.FormFile("file")
-		if err != nil {
-			log.Error().Err(err).Msg("cant read file form")
-			respond(ErrorResponse{
-				Status:  "error",
-				Message: err.Error(),
-			}, http.StatusBadRequest, w)
-			return
-		}
-		defer file.Close()
+func LoadHandler(rt *Rtorrent, config LoadConfig) http.HandlerFunc {
+	client := newFetchClient(config)
 
-		buffer := bytes.NewBuffer(nil)
-		_, err = io.Copy(buffer, file)
-		if err != nil {
-			log.Error().Err(err).Msg("cant copy file to buffer")
-			respond(ErrorResponse{
-				Status:  "error",
-				Message: err.Error(),
-			}, http.StatusBadRequest, w)
-			return
+	return func(w http.ResponseWriter, r *http.Request) {
+		// loading many torrents can take longer than the server timeouts
+		if config.Timeout > 0 {
+			rc := http.NewResponseController(w)
+			rc.SetReadDeadline(time.Now().Add(config.Timeout))
+			rc.SetWriteDeadline(time.Now().Add(config.Timeout))
 		}
-
-		err = rt.LoadRawStart(buffer.Bytes())
-		if err != nil {
-			log.Error().Err(err).Msg("xmlrpc load raw start failed")
-			respond(ErrorResponse{
-				Status:  "error",
-				Message: err.Error(),
-			}, http.StatusInternalServerError, w)
-			return
+		if config.MaxRequestSize > 0 {
+			r.Body = http.MaxBytesReader(w, r.Body, config.MaxRequestSize)
 		}
-		respond(Response{
-			Status: "ok",
-		}, http.StatusOK, w)
-	}
-}
 
-func TorrentHandler(rt *Rtorrent) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		vars := mux.Vars(r)
+		var items []loadItem
+		var req LoadOptionsRequest
 
-		if vars["action"] == "stop" {
-			err := rt.Stop(vars["hash"])
+		ct, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		if ct == "application/json" {
+			decoder := json.NewDecoder(r.Body)
+			var body LoadRequest
+			err := decoder.Decode(&body)
 			if err != nil {
-				log.Error().Err(err).Msg("unable to stop torrent in action")
+				log.Error().Err(err).Msg("cant decode load request json")
 				respond(ErrorResponse{
 					Status:  "error",
+					Code:    ErrorCodeBadRequest,
 					Message: err.Error(),
-				}, http.StatusInternalServerError, w)
+				}, http.StatusBadRequest, w)
 				return
 			}
-			respond(Response{
-				Status: "ok",
-			}, http.StatusOK, w)
-			return
-		}
 
-		if vars["action"] == "pause" {
-			err := rt.Pause(vars["hash"])
+			for _, uri := range body.URIs {
+				items = append(items, loadItem{uri: uri})
+			}
+			req = body.LoadOptionsRequest
+		} else {
+			err := r.ParseMultipartForm(10 << 20)
 			if err != nil {
-				log.Error().Err(err).Msg("unable to pause torrent in action")
+				log.Error().Err(err).Msg("cant parse multipart form")
 				respond(ErrorResponse{
 					Status:  "error",
+					Code:    ErrorCodeBadRequest,
 					Message: err.Error(),
-				}, http.StatusInternalServerError, w)
+				}, http.StatusBadRequest, w)
 				return
 			}
-			respond(Response{
-				Status: "ok",
-			}, http.StatusOK, w)
-			return
-		}
+			defer r.MultipartForm.RemoveAll()
 
-		if vars["action"] == "resume" {
-			err := rt.Resume(vars["hash"])
+			items = formItems(r.MultipartForm, config.MaxTorrentSize)
+			req, err = loadOptionsForm(r)
 			if err != nil {
-				log.Error().Err(err).Msg("unable to resume torrent in action")
-				respond(ErrorResponse{
-					Status:  "error",
-					Message: err.Error(),
-				}, http.StatusInternalServerError, w)
+				respondError(err, w)
 				return
 			}
-			respond(Response{
-				Status: "ok",
-			}, http.StatusOK, w)
+		}
+
+		if len(items) == 0 {
+			respond(ErrorResponse{
+				Status:  "error",
+				Code:    ErrorCodeBadRequest,
+				Message: "no torrents in request",
+			}, http.StatusBadRequest, w)
 			return
 		}
 
-		if vars["action"] == "start" {
-			err := rt.Start(vars["hash"])
+		options, err := loadOptions(req, rt.labelDelimiter)
+		if err != nil {
+			respondError(err, w)
+			return
+		}
+		// torrents of users restricted to labels get the first of their labels
+		if scope := scopeFromContext(r.Context()); len(scope) > 0 {
+			options.Custom[0], err = stampScope(options.Custom[0], scope, rt.labelDelimiter)
 			if err != nil {
-				log.Error().Err(err).Msg("unable to start torrent in action")
-				respond(ErrorResponse{
-					Status:  "error",
-					Message: err.Error(),
-				}, http.StatusBadRequest, w)
+				respondError(err, w)
 				return
 			}
-			respond(Response{
-				Status: "ok",
-			}, http.StatusOK, w)
+		}
+		// reject invalid options before loading anything
+		_, err = options.commands()
+		if err != nil {
+			respondError(err, w)
 			return
 		}
 
-		if vars["action"] == "hash" {
-			err := rt.CheckHash(vars["hash"])
-			if err != nil {
-				log.Error().Err(err).Msg("unable to hash torrent in action")
-				respond(ErrorResponse{
-					Status:  "error",
-					Message: err.Error(),
-				}, http.StatusBadRequest, w)
-				return
-			}
-			respond(Response{
-				Status: "ok",
-			}, http.StatusOK, w)
+		results, errs := loadBatch(r.Context(), rt, client, items, options, config)
+		for i, result := range results {
+			auditTorrent(r.Context(), result.Hash, result.Name, errs[i])
+		}
+
+		// a single torrent keeps responding with a plain error
+		if len(results) == 1 && errs[0] != nil {
+			respondError(errs[0], w)
 			return
 		}
 
-		if vars["action"] == "erase" {
-			err := rt.Erase(vars["hash"])
+		status := "ok"
+		for _, err := range errs {
 			if err != nil {
-				log.Error().Err(err).Msg("unable to erase torrent in action")
-				respond(ErrorResponse{
-					Status:  "error",
-					Message: err.Error(),
-				}, http.StatusBadRequest, w)
-				return
+				status = "error"
 			}
-			respond(Response{
-				Status: "ok",
-			}, http.StatusOK, w)
-			return
 		}
+		respond(LoadResponse{
+			Status:   status,
+			Torrents: results,
+		}, http.StatusOK, w)
+	}
+}
 
-		if vars["action"] == "files" {
-			args := []interface{}{vars["hash"], "",
-				"f.path=", "f.size_bytes=", "f.size_chunks=",
-				"f.completed_chunks=", "f.frozen_path=", "f.priority=",
-				"f.is_created=", "f.is_open="}
+func ActionsHandler(rt *Rtorrent) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		decoder := json.NewDecoder(r.Body)
+		var req ActionRequest
+		err := decoder.Decode(&req)
+		if err != nil {
+			log.Error().Err(err).Msg("cant decode action request json")
+			respond(ErrorResponse{
+				Status:  "error",
+				Code:    ErrorCodeBadRequest,
+				Message: err.Error(),
+			}, http.StatusBadRequest, w)
+			return
+		}
 
-			files, err := rt.FMulticall(args)
+		// bulk actions require the same role as on a single torrent
+		if role, ok := TorrentActionRoles[req.Action]; ok {
+			err = checkRole(r, role)
 			if err != nil {
-				log.Error().Err(err).Msg("unable to fetch files in torrent action")
-				respond(ErrorResponse{
-					Status:  "error",
-					Message: err.Error(),
-				}, http.StatusBadRequest, w)
+				respondError(err, w)
 				return
 			}
+		}
 
-			respond(FilesResponse{
-				Status: "ok",
-				Files:  files,
-			}, http.StatusOK, w)
+		hashes, err := actionHashes(r.Context(), rt, req)
+		if err != nil {
+			log.Error().Err(err).Msg("cant resolve action hashes")
+			respondError(err, w)
 			return
 		}
 
-		if vars["action"] == "peers" {
-			args := []interface{}{vars["hash"], "",
-				"p.id=", "p.address=", "p.port=",
-				"p.banned=", "p.client_version=", "p.completed_percent=",
-				"p.is_encrypted=", "p.is_incoming=", "p.is_obfuscated=",
-				"p.peer_rate=", "p.peer_total=", "p.up_rate=", "p.up_total="}
+		calls, err := actionCalls(req, hashes, rt.labelDelimiter)
+		if err == nil {
+			err = checkLabelCalls(r.Context(), calls, rt.labelDelimiter)
+		}
+		if err != nil {
+			respondError(err, w)
+			return
+		}
 
-			peers, err := rt.PMulticall(args)
-			if err != nil {
-				log.Error().Err(err).Msg("unable to fetch peers in torrent action")
-				respond(ErrorResponse{
-					Status:  "error",
-					Message: err.Error(),
-				}, http.StatusBadRequest, w)
-				return
-			}
+		results, err := actionResults(r.Context(), rt, hashes, calls)
+		if err != nil {
+			log.Error().Err(err).Msg("unable to perform bulk action")
+			respondError(err, w)
+			return
+		}
 
-			respond(PeersResponse{
-				Status: "ok",
-				Peers:  peers,
-			}, http.StatusOK, w)
+		respond(ActionResponse{
+			Status:  actionStatus(results),
+			Results: results,
+		}, http.StatusOK, w)
+	}
+}
+
+func LabelsHandler(rt *Rtorrent) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, info := withCacheInfo(r.Context())
+
+		view := r.URL.Query().Get("view")
+		if view == "" {
+			view = "main"
+		}
+
+		commands, err := fieldCommands[Torrent]([]string{"custom1", "size_bytes", "completed_bytes", "upload_rate", "download_rate"})
+		if err != nil {
+			respondError(err, w)
+			return
+		}
+		torrents, err := multicall[Torrent](ctx, rt, "d.multicall2", []interface{}{"", view}, commands)
+		if err != nil {
+			log.Error().Err(err).Msg("cant fetch labels")
+			respondError(err, w)
 			return
 		}
 
-		if vars["action"] == "trackers" {
-			args := []interface{}{vars["hash"], "",
-				"t.id=", "t.type=", "t.url=",
-				"t.activity_time_last=", "t.activity_time_next=", "t.can_scrape=",
-				"t.is_usable=", "t.is_enabled=", "t.failed_counter=",
-				"t.failed_time_last=", "t.failed_time_next=", "t.is_busy=",
-				"t.is_open=",
-			}
+		torrents = filterScope(torrents, scopeFromContext(ctx), rt.labelDelimiter)
+		labels, unlabeled := labelStats(torrents, rt.labelDelimiter)
+		respondCached(LabelsResponse{
+			Status:    "ok",
+			Labels:    labels,
+			Unlabeled: unlabeled,
+		}, w, r, info.Modified())
+	}
+}
 
-			trackers, err := rt.TMulticall(args)
-			if err != nil {
-				log.Error().Err(err).Msg("unable to fetch trackers in torrent action")
-				respond(ErrorResponse{
-					Status:  "error",
-					Message: err.Error(),
-				}, http.StatusBadRequest, w)
-				return
-			}
+func LabelActionsHandler(rt *Rtorrent) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		decoder := json.NewDecoder(r.Body)
+		var req LabelRequest
+		err := decoder.Decode(&req)
+		if err != nil {
+			log.Error().Err(err).Msg("cant decode label request json")
+			respond(ErrorResponse{
+				Status:  "error",
+				Code:    ErrorCodeBadRequest,
+				Message: err.Error(),
+			}, http.StatusBadRequest, w)
+			return
+		}
 
-			respond(TrackersResponse{
-				Status:   "ok",
-				Trackers: trackers,
-			}, http.StatusOK, w)
+		hashes, err := actionHashes(r.Context(), rt, ActionRequest{
+			Hashes: req.Hashes,
+			View:   req.View,
+			Filter: req.Filter,
+		})
+		if err != nil {
+			log.Error().Err(err).Msg("cant resolve label hashes")
+			respondError(err, w)
 			return
 		}
 
-		if vars["action"] == "priority" {
-			decoder := json.NewDecoder(r.Body)
-			var req TorrentPriorityRequest
-			err := decoder.Decode(&req)
+		// current labels are only needed to add and remove labels
+		current := make(map[string]string)
+		if req.Action == "add" || req.Action == "remove" {
+			current, err = torrentLabels(r.Context(), rt)
 			if err != nil {
-				log.Error().Err(err).Msg("unable to decode priority request")
-				respond(ErrorResponse{
-					Status:  "error",
-					Message: err.Error(),
-				}, http.StatusOK, w)
+				log.Error().Err(err).Msg("cant fetch labels")
+				respondError(err, w)
 				return
 			}
+		}
 
-			err = rt.Priority(vars["hash"], req.Priority)
-			if err != nil {
-				log.Error().Err(err).Msg("unable to set torrent priority")
-				respond(ErrorResponse{
-					Status:  "error",
-					Message: err.Error(),
-				}, http.StatusBadRequest, w)
-				return
-			}
+		calls, err := labelCalls(req, hashes, current, rt.labelDelimiter)
+		if err == nil {
+			err = checkLabelCalls(r.Context(), calls, rt.labelDelimiter)
+		}
+		if err != nil {
+			respondError(err, w)
+			return
+		}
 
-			respond(Response{
-				Status: "ok",
-			}, http.StatusOK, w)
+		results, err := actionResults(r.Context(), rt, hashes, calls)
+		if err != nil {
+			log.Error().Err(err).Msg("unable to change labels")
+			respondError(err, w)
 			return
 		}
+
+		respond(ActionResponse{
+			Status:  actionStatus(results),
+			Results: results,
+		}, http.StatusOK, w)
+	}
+}
+
+func LabelRenameHandler(rt *Rtorrent) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		decoder := json.NewDecoder(r.Body)
+		var req LabelRenameRequest
+		err := decoder.Decode(&req)
+		if err != nil {
+			log.Error().Err(err).Msg("cant decode label rename request json")
+			respond(ErrorResponse{
+				Status:  "error",
+				Code:    ErrorCodeBadRequest,
+				Message: err.Error(),
+			}, http.StatusBadRequest, w)
+			return
+		}
+
+		current, err := torrentLabels(r.Context(), rt)
+		if err != nil {
+			log.Error().Err(err).Msg("cant fetch labels")
+			respondError(err, w)
+			return
+		}
+
+		hashes, calls, err := renameCalls(req.From, req.To, current, rt.labelDelimiter)
+		if err == nil {
+			err = checkLabelCalls(r.Context(), calls, rt.labelDelimiter)
+		}
+		if err != nil {
+			respondError(err, w)
+			return
+		}
+
+		results, err := actionResults(r.Context(), rt, hashes, calls)
+		if err != nil {
+			log.Error().Err(err).Msg("unable to rename label")
+			respondError(err, w)
+			return
+		}
+
+		respond(ActionResponse{
+			Status:  actionStatus(results),
+			Results: results,
+		}, http.StatusOK, w)
+	}
+}
+
+func FilePriorityHandler(rt *Rtorrent) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+
+		decoder := json.NewDecoder(r.Body)
+		var req FilePriorityRequest
+		err := decoder.Decode(&req)
+		if err != nil {
+			log.Error().Err(err).Msg("cant decode file priority request json")
+			respond(ErrorResponse{
+				Status:  "error",
+				Code:    ErrorCodeBadRequest,
+				Message: err.Error(),
+			}, http.StatusBadRequest, w)
+			return
+		}
+
+		files, err := Multicall[File](r.Context(), rt, "f.multicall", vars["hash"], "")
+		if err != nil {
+			log.Error().Err(err).Msg("unable to fetch files for file priority")
+			respondError(err, w)
+			return
+		}
+
+		priorities, err := filePriorities(files, req)
+		if err != nil {
+			respondError(err, w)
+			return
+		}
+		if len(priorities) > 0 {
+			err = rt.FilePrioritiesContext(r.Context(), vars["hash"], priorities)
+			if err != nil {
+				log.Error().Err(err).Msg("unable to set file priorities")
+				respondError(err, w)
+				return
+			}
+		}
+
+		for i, p := range priorities {
+			files[i].Priority = int64(p)
+		}
+		respond(FilesResponse{
+			Status: "ok",
+			Files:  files,
+		}, http.StatusOK, w)
+	}
+}
+
+func TrackersHandler(rt *Rtorrent) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+
+		decoder := json.NewDecoder(r.Body)
+		var req TrackerRequest
+		err := decoder.Decode(&req)
+		if err != nil {
+			log.Error().Err(err).Msg("cant decode tracker request json")
+			respond(ErrorResponse{
+				Status:  "error",
+				Code:    ErrorCodeBadRequest,
+				Message: err.Error(),
+			}, http.StatusBadRequest, w)
+			return
+		}
+
+		trackers, err := Multicall[Tracker](r.Context(), rt, "t.multicall", vars["hash"], "")
+		if err != nil {
+			log.Error().Err(err).Msg("unable to fetch trackers for tracker action")
+			respondError(err, w)
+			return
+		}
+
+		calls, err := trackerCalls(vars["hash"], req, trackers)
+		if err != nil {
+			respondError(err, w)
+			return
+		}
+
+		errs, err := rt.BatchContext(r.Context(), calls)
+		if err == nil {
+			err = errors.Join(errs...)
+		}
+		if err != nil {
+			log.Error().Err(err).Msg("unable to perform tracker action")
+			respondError(err, w)
+			return
+		}
+
+		trackers, err = Multicall[Tracker](r.Context(), rt, "t.multicall", vars["hash"], "")
+		if err != nil {
+			log.Error().Err(err).Msg("unable to fetch trackers for tracker action")
+			respondError(err, w)
+			return
+		}
+
+		respond(TrackersResponse{
+			Status:   "ok",
+			Trackers: trackers,
+		}, http.StatusOK, w)
+	}
+}
+
+func PeersHandler(rt *Rtorrent) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+
+		decoder := json.NewDecoder(r.Body)
+		var req PeerRequest
+		err := decoder.Decode(&req)
+		if err != nil {
+			log.Error().Err(err).Msg("cant decode peer request json")
+			respond(ErrorResponse{
+				Status:  "error",
+				Code:    ErrorCodeBadRequest,
+				Message: err.Error(),
+			}, http.StatusBadRequest, w)
+			return
+		}
+
+		calls, err := peerCalls(vars["hash"], req)
+		if err != nil {
+			respondError(err, w)
+			return
+		}
+
+		errs, err := rt.BatchContext(r.Context(), calls)
+		if err == nil {
+			err = errors.Join(errs...)
+		}
+		if err != nil {
+			log.Error().Err(err).Msg("unable to perform peer action")
+			respondError(err, w)
+			return
+		}
+
+		peers, err := Multicall[Peer](r.Context(), rt, "p.multicall", vars["hash"], "")
+		if err != nil {
+			log.Error().Err(err).Msg("unable to fetch peers for peer action")
+			respondError(err, w)
+			return
+		}
+
+		respond(PeersResponse{
+			Status: "ok",
+			Peers:  peers,
+		}, http.StatusOK, w)
+	}
+}
+
+func TrackerReplaceHandler(rt *Rtorrent) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		decoder := json.NewDecoder(r.Body)
+		var req TrackerReplaceRequest
+		err := decoder.Decode(&req)
+		if err != nil {
+			log.Error().Err(err).Msg("cant decode tracker replace request json")
+			respond(ErrorResponse{
+				Status:  "error",
+				Code:    ErrorCodeBadRequest,
+				Message: err.Error(),
+			}, http.StatusBadRequest, w)
+			return
+		}
+
+		if req.From == "" {
+			respond(ErrorResponse{
+				Status:  "error",
+				Code:    ErrorCodeBadRequest,
+				Message: "from is required",
+			}, http.StatusBadRequest, w)
+			return
+		}
+		err = validateTrackerURL(req.To)
+		if err != nil {
+			respondError(err, w)
+			return
+		}
+
+		hashes, err := actionHashes(r.Context(), rt, ActionRequest{
+			Hashes: req.Hashes,
+			View:   req.View,
+			Filter: req.Filter,
+		})
+		if err != nil {
+			log.Error().Err(err).Msg("cant resolve tracker replace hashes")
+			respondError(err, w)
+			return
+		}
+
+		trackers, errs, err := batchMulticall[Tracker](r.Context(), rt, "t.multicall", hashes)
+		if err != nil {
+			log.Error().Err(err).Msg("unable to fetch trackers for tracker replace")
+			respondError(err, w)
+			return
+		}
+
+		results := make([]TrackerReplaceResult, 0)
+		calls := make([]SystemCall, 0)
+		// index of the result each call belongs to
+		owners := make([]int, 0)
+		for i, hash := range hashes {
+			result := TrackerReplaceResult{Hash: hash, Status: "ok", Changes: []TrackerChange{}}
+			if errs[i] != nil {
+				_, code := errorStatus(errs[i])
+				result.Status = "error"
+				result.Code = code
+				result.Error = errs[i].Error()
+				results = append(results, result)
+				continue
+			}
+
+			changes, torrentCalls := replaceCalls(hash, trackers[i], req.From, req.To)
+			if len(changes) == 0 {
+				continue
+			}
+			result.Changes = changes
+			results = append(results, result)
+			for range torrentCalls {
+				owners = append(owners, len(results)-1)
+			}
+			calls = append(calls, torrentCalls...)
+		}
+
+		if !req.DryRun && len(calls) > 0 {
+			errs, err := rt.BatchContext(r.Context(), calls)
+			if err != nil {
+				log.Error().Err(err).Msg("unable to replace trackers")
+				respondError(err, w)
+				return
+			}
+			for i, err := range errs {
+				result := &results[owners[i]]
+				if err == nil || result.Status != "ok" {
+					continue
+				}
+				_, code := errorStatus(err)
+				result.Status = "error"
+				result.Code = code
+				result.Error = err.Error()
+			}
+		}
+
+		status := "ok"
+		for _, result := range results {
+			if result.Status != "ok" {
+				status = "error"
+			}
+			if !req.DryRun {
+				var err error
+				if result.Error != "" {
+					err = errors.New(result.Error)
+				}
+				auditTorrent(r.Context(), result.Hash, "", err)
+			}
+		}
+		respond(TrackerReplaceResponse{
+			Status:   status,
+			DryRun:   req.DryRun,
+			Torrents: results,
+		}, http.StatusOK, w)
+	}
+}
+
+func MoveHandler(jobs *MoveJobs) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+
+		decoder := json.NewDecoder(r.Body)
+		var req MoveRequest
+		err := decoder.Decode(&req)
+		if err != nil {
+			log.Error().Err(err).Msg("cant decode move request json")
+			respond(ErrorResponse{
+				Status:  "error",
+				Code:    ErrorCodeBadRequest,
+				Message: err.Error(),
+			}, http.StatusBadRequest, w)
+			return
+		}
+
+		job, err := jobs.Start(vars["hash"], req.Directory)
+		if err != nil {
+			respondError(err, w)
+			return
+		}
+
+		respond(MoveJobResponse{
+			Status: "ok",
+			Job:    job,
+		}, http.StatusAccepted, w)
+	}
+}
+
+// Returns a move job, users restricted to labels only see jobs of their torrents
+func MoveJobHandler(rt *Rtorrent, jobs *MoveJobs) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+
+		job, ok := jobs.Get(vars["id"])
+		if !ok {
+			respond(ErrorResponse{
+				Status:  "error",
+				Code:    ErrorCodeNotFound,
+				Message: "job not found",
+			}, http.StatusNotFound, w)
+			return
+		}
+
+		err := checkScope(r.Context(), rt, job.Hash)
+		if err != nil {
+			respondError(err, w)
+			return
+		}
+
+		respond(MoveJobResponse{
+			Status: "ok",
+			Job:    job,
+		}, http.StatusOK, w)
+	}
+}
+
+func EraseHandler(rt *Rtorrent, config EraseConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		query := r.URL.Query()
+
+		data, err := queryBool(query, "data")
+		if err != nil {
+			respondError(err, w)
+			return
+		}
+		dryRun, err := queryBool(query, "dry_run")
+		if err != nil {
+			respondError(err, w)
+			return
+		}
+
+		if !data {
+			if dryRun {
+				respond(EraseResponse{
+					Status: "ok",
+					DryRun: true,
+					Paths:  []string{},
+				}, http.StatusOK, w)
+				return
+			}
+
+			err := rt.EraseContext(r.Context(), vars["hash"])
+			if err != nil {
+				log.Error().Err(err).Msg("unable to erase torrent in action")
+				respondError(err, w)
+				return
+			}
+			respond(EraseResponse{
+				Status: "ok",
+				Paths:  []string{},
+			}, http.StatusOK, w)
+			return
+		}
+
+		paths, err := EraseWithData(r.Context(), rt, config, vars["hash"], dryRun)
+		if err != nil {
+			log.Error().Err(err).Strs("paths", paths).Msg("unable to erase torrent with data")
+			respondError(err, w)
+			return
+		}
+		log.Info().Str("hash", vars["hash"]).Bool("dry_run", dryRun).Strs("paths", paths).Msg("erased torrent with data")
+
+		respond(EraseResponse{
+			Status: "ok",
+			DryRun: dryRun,
+			Paths:  paths,
+		}, http.StatusOK, w)
+	}
+}
+
+// Answers deprecated GET requests of torrent actions that change state with 405 and the route replacing them
+func UnsafeGetHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+
+		replacement := "POST /api/v1/torrents/" + vars["hash"] + "/" + vars["action"]
+		switch vars["action"] {
+		case "erase":
+			replacement = "DELETE /api/v1/torrents/" + vars["hash"]
+		case "priority":
+			replacement = "PATCH /api/v1/torrents/" + vars["hash"]
+		}
+
+		w.Header().Set("Allow", http.MethodPost)
+		respond(ErrorResponse{
+			Status:  "error",
+			Code:    ErrorCodeMethodNotAllowed,
+			Message: vars["action"] + " changes the torrent and is not allowed over GET, use " + replacement,
+		}, http.StatusMethodNotAllowed, w)
+	}
+}
+
+// Handles the deprecated /torrent/{hash}/{action} routes, unknown actions are not found
+func TorrentHandler(rt *Rtorrent) http.HandlerFunc {
+	files := TorrentFilesHandler(rt)
+	peers := TorrentPeersHandler(rt)
+	trackers := TorrentTrackersHandler(rt)
+	priority := TorrentPriorityHandler(rt)
+	action := TorrentActionHandler(rt)
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch mux.Vars(r)["action"] {
+		case "files":
+			files(w, r)
+		case "peers":
+			peers(w, r)
+		case "trackers":
+			trackers(w, r)
+		case "priority":
+			priority(w, r)
+		default:
+			action(w, r)
+		}
+	}
+}
+
+// Performs one of the torrent actions without parameters
+func TorrentActionHandler(rt *Rtorrent) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+
+		method, ok := torrentActions[vars["action"]]
+		if !ok {
+			respond(ErrorResponse{
+				Status:  "error",
+				Code:    ErrorCodeNotFound,
+				Message: "unknown action " + vars["action"],
+			}, http.StatusNotFound, w)
+			return
+		}
+
+		err := rt.call(r.Context(), method, vars["hash"], nil)
+		if err != nil {
+			log.Error().Err(err).Str("action", vars["action"]).Msg("unable to perform torrent action")
+			respondError(err, w)
+			return
+		}
+
+		respond(Response{
+			Status: "ok",
+		}, http.StatusOK, w)
+	}
+}
+
+func TorrentDetailsHandler(rt *Rtorrent) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		ctx, info := withCacheInfo(r.Context())
+
+		torrent, err := rt.TorrentContext(ctx, vars["hash"])
+		if err != nil {
+			log.Error().Err(err).Msg("unable to fetch torrent")
+			respondError(err, w)
+			return
+		}
+
+		respondCached(TorrentResponse{
+			Status:  "ok",
+			Torrent: torrent,
+		}, w, r, info.Modified())
+	}
+}
+
+// Changes the priority, label or directory of a torrent. Changing the directory does not
+// move data and requires the admin role.
+func TorrentUpdateHandler(rt *Rtorrent) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+
+		decoder := json.NewDecoder(r.Body)
+		var req TorrentUpdateRequest
+		err := decoder.Decode(&req)
+		if err != nil {
+			log.Error().Err(err).Msg("cant decode torrent update request json")
+			respond(ErrorResponse{
+				Status:  "error",
+				Code:    ErrorCodeBadRequest,
+				Message: err.Error(),
+			}, http.StatusBadRequest, w)
+			return
+		}
+
+		if req.Directory != nil {
+			err := checkRole(r, RoleAdmin)
+			if err != nil {
+				respondError(err, w)
+				return
+			}
+		}
+
+		torrent, err := rt.TorrentContext(r.Context(), vars["hash"])
+		if err != nil {
+			log.Error().Err(err).Msg("unable to fetch torrent for update")
+			respondError(err, w)
+			return
+		}
+
+		calls, err := updateCalls(vars["hash"], req, torrent, rt.labelDelimiter)
+		if err == nil {
+			err = checkLabelCalls(r.Context(), calls, rt.labelDelimiter)
+		}
+		if err != nil {
+			respondError(err, w)
+			return
+		}
+
+		errs, err := rt.BatchContext(r.Context(), calls)
+		if err == nil {
+			err = errors.Join(errs...)
+		}
+		if err != nil {
+			log.Error().Err(err).Msg("unable to update torrent")
+			respondError(err, w)
+			return
+		}
+
+		torrent, err = rt.TorrentContext(r.Context(), vars["hash"])
+		if err != nil {
+			log.Error().Err(err).Msg("unable to fetch torrent after update")
+			respondError(err, w)
+			return
+		}
+
+		respond(TorrentResponse{
+			Status:  "ok",
+			Torrent: torrent,
+		}, http.StatusOK, w)
+	}
+}
+
+func TorrentFilesHandler(rt *Rtorrent) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		ctx, info := withCacheInfo(r.Context())
+
+		files, err := Multicall[File](ctx, rt, "f.multicall", vars["hash"], "")
+		if err != nil {
+			log.Error().Err(err).Msg("unable to fetch files in torrent action")
+			respondError(err, w)
+			return
+		}
+
+		respondCached(FilesResponse{
+			Status: "ok",
+			Files:  files,
+		}, w, r, info.Modified())
+	}
+}
+
+func TorrentPeersHandler(rt *Rtorrent) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		ctx, info := withCacheInfo(r.Context())
+
+		peers, err := Multicall[Peer](ctx, rt, "p.multicall", vars["hash"], "")
+		if err != nil {
+			log.Error().Err(err).Msg("unable to fetch peers in torrent action")
+			respondError(err, w)
+			return
+		}
+
+		respondCached(PeersResponse{
+			Status: "ok",
+			Peers:  peers,
+		}, w, r, info.Modified())
+	}
+}
+
+func TorrentTrackersHandler(rt *Rtorrent) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		ctx, info := withCacheInfo(r.Context())
+
+		trackers, err := Multicall[Tracker](ctx, rt, "t.multicall", vars["hash"], "")
+		if err != nil {
+			log.Error().Err(err).Msg("unable to fetch trackers in torrent action")
+			respondError(err, w)
+			return
+		}
+
+		respondCached(TrackersResponse{
+			Status:   "ok",
+			Trackers: trackers,
+		}, w, r, info.Modified())
+	}
+}
+
+func TorrentPriorityHandler(rt *Rtorrent) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+
+		decoder := json.NewDecoder(r.Body)
+		var req TorrentPriorityRequest
+		err := decoder.Decode(&req)
+		if err != nil {
+			log.Error().Err(err).Msg("unable to decode priority request")
+			respond(ErrorResponse{
+				Status:  "error",
+				Code:    ErrorCodeBadRequest,
+				Message: err.Error(),
+			}, http.StatusBadRequest, w)
+			return
+		}
+
+		err = rt.PriorityContext(r.Context(), vars["hash"], req.Priority)
+		if err != nil {
+			log.Error().Err(err).Msg("unable to set torrent priority")
+			respondError(err, w)
+			return
+		}
+
+		respond(Response{
+			Status: "ok",
+		}, http.StatusOK, w)
+	}
+}
+
+func EventsHandler(events *Events) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			respond(ErrorResponse{
+				Status:  "error",
+				Code:    ErrorCodeInternal,
+				Message: "streaming is not supported",
+			}, http.StatusInternalServerError, w)
+			return
+		}
+
+		// the stream outlives the server write timeout
+		rc := http.NewResponseController(w)
+		err := rc.SetWriteDeadline(time.Time{})
+		if err != nil {
+			log.Error().Err(err).Msg("cant clear write deadline for events")
+		}
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("Connection", "keep-alive")
+		w.WriteHeader(http.StatusOK)
+		flusher.Flush()
+
+		ch := events.Subscribe(scopeFromContext(r.Context()))
+		defer events.Unsubscribe(ch)
+
+		heartbeat := time.NewTicker(15 * time.Second)
+		defer heartbeat.Stop()
+
+		for {
+			select {
+			case <-r.Context().Done():
+				return
+			case msg, ok := <-ch:
+				if !ok {
+					return
+				}
+				_, err := w.Write(msg)
+				if err != nil {
+					return
+				}
+				flusher.Flush()
+			case <-heartbeat.C:
+				_, err := w.Write([]byte(": heartbeat\n\n"))
+				if err != nil {
+					return
+				}
+				flusher.Flush()
+			}
+		}
+	}
+}
+
+func LoginHandler(auth *Auth) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		decoder := json.NewDecoder(r.Body)
+		var req LoginRequest
+		err := decoder.Decode(&req)
+		if err != nil {
+			log.Error().Err(err).Msg("cant decode login request json")
+			respond(ErrorResponse{
+				Status:  "error",
+				Code:    ErrorCodeBadRequest,
+				Message: err.Error(),
+			}, http.StatusBadRequest, w)
+			return
+		}
+
+		id, user, err := auth.Login(req.Username, req.Password)
+		if err != nil {
+			log.Warn().Err(err).Str("user", req.Username).Str("remote", r.RemoteAddr).Msg("login failed")
+			respondError(err, w)
+			return
+		}
+
+		http.SetCookie(w, auth.cookie(id, r))
+		respond(UserResponse{
+			Status: "ok",
+			User:   user,
+		}, http.StatusOK, w)
+	}
+}
+
+func LogoutHandler(auth *Auth) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if cookie, err := r.Cookie(SessionCookie); err == nil {
+			auth.Logout(cookie.Value)
+		}
+
+		http.SetCookie(w, auth.cookie("", r))
+		respond(Response{
+			Status:  "ok",
+			Message: "logged out",
+		}, http.StatusOK, w)
+	}
+}
+
+func MeHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, _ := UserFromContext(r.Context())
+		respond(UserResponse{
+			Status: "ok",
+			User:   user,
+		}, http.StatusOK, w)
+	}
+}
+
+func TokensHandler(auth *Auth) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, _ := UserFromContext(r.Context())
+
+		if r.Method == http.MethodGet {
+			respond(TokensResponse{
+				Status: "ok",
+				Tokens: auth.Tokens(user),
+			}, http.StatusOK, w)
+			return
+		}
+
+		decoder := json.NewDecoder(r.Body)
+		var req TokenRequest
+		err := decoder.Decode(&req)
+		if err != nil {
+			log.Error().Err(err).Msg("cant decode token request json")
+			respond(ErrorResponse{
+				Status:  "error",
+				Code:    ErrorCodeBadRequest,
+				Message: err.Error(),
+			}, http.StatusBadRequest, w)
+			return
+		}
+
+		token, err := auth.CreateToken(user, req.Name)
+		if err != nil {
+			log.Error().Err(err).Msg("cant create token")
+			respondError(err, w)
+			return
+		}
+
+		respond(TokenResponse{
+			Status: "ok",
+			Name:   req.Name,
+			Token:  token,
+		}, http.StatusCreated, w)
+	}
+}
+
+func TokenDeleteHandler(auth *Auth) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		user, _ := UserFromContext(r.Context())
+
+		err := auth.DeleteToken(user, vars["name"])
+		if err != nil {
+			respondError(err, w)
+			return
+		}
+
+		respond(Response{
+			Status:  "ok",
+			Message: "token deleted",
+		}, http.StatusOK, w)
+	}
+}
+
+func AuditHandler(audit *AuditLog) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		query, err := ParseAuditQuery(r.URL.Query())
+		if err != nil {
+			respondError(err, w)
+			return
+		}
+
+		entries, err := audit.Query(query)
+		if err != nil {
+			log.Error().Err(err).Msg("cant read audit log")
+			respondError(err, w)
+			return
+		}
+
+		respond(AuditResponse{
+			Status:  "ok",
+			Entries: entries,
+		}, http.StatusOK, w)
 	}
 }
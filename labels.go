@@ -0,0 +1,218 @@
+package kahva
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// Default delimiter between multiple labels in d.custom1
+const DefaultLabelDelimiter = ","
+
+// Returns the labels stored in d.custom1. Labels are URL encoded like ruTorrent does,
+// values that are not valid encodings are used as is. Without a delimiter custom1 is a single label.
+func parseLabels(custom1 string, delimiter string) []string {
+	parts := []string{custom1}
+	if delimiter != "" {
+		parts = strings.Split(custom1, delimiter)
+	}
+
+	labels := make([]string, 0, len(parts))
+	seen := make(map[string]bool)
+	for _, part := range parts {
+		label, err := url.PathUnescape(strings.TrimSpace(part))
+		if err != nil {
+			label = strings.TrimSpace(part)
+		}
+		if label == "" || seen[label] {
+			continue
+		}
+		seen[label] = true
+		labels = append(labels, label)
+	}
+	return labels
+}
+
+// Returns the d.custom1 value for labels. Empty and duplicate labels are dropped.
+func formatLabels(labels []string, delimiter string) (string, error) {
+	if delimiter == "" && len(labels) > 1 {
+		return "", fmt.Errorf("%w: multiple labels require a label delimiter", ErrInvalidArgument)
+	}
+
+	parts := make([]string, 0, len(labels))
+	seen := make(map[string]bool)
+	for _, label := range labels {
+		label = strings.TrimSpace(label)
+		if label == "" || seen[label] {
+			continue
+		}
+		seen[label] = true
+		parts = append(parts, escapeLabel(label, delimiter))
+	}
+	return strings.Join(parts, delimiter), nil
+}
+
+// URL encodes a label, the delimiter is always encoded even if it is a character
+// that does not need escaping
+func escapeLabel(label string, delimiter string) string {
+	escaped := url.PathEscape(label)
+	if delimiter == "" || !strings.Contains(escaped, delimiter) {
+		return escaped
+	}
+
+	var b strings.Builder
+	for _, c := range []byte(delimiter) {
+		fmt.Fprintf(&b, "%%%02X", c)
+	}
+	return strings.ReplaceAll(escaped, delimiter, b.String())
+}
+
+// Returns true if the torrent has the label, an empty label matches torrents without labels
+func hasLabel(custom1 string, label string, delimiter string) bool {
+	labels := parseLabels(custom1, delimiter)
+	if label == "" {
+		return len(labels) == 0
+	}
+	for _, l := range labels {
+		if l == label {
+			return true
+		}
+	}
+	return false
+}
+
+// Aggregates torrents by label. Torrents with multiple labels are counted for each of them.
+// Returns the labels sorted by name and the totals of torrents without labels.
+func labelStats(torrents []Torrent, delimiter string) ([]LabelInfo, LabelInfo) {
+	stats := make(map[string]*LabelInfo)
+	unlabeled := LabelInfo{}
+	for _, t := range torrents {
+		labels := parseLabels(t.Custom1, delimiter)
+		if len(labels) == 0 {
+			unlabeled.add(t)
+			continue
+		}
+		for _, label := range labels {
+			info, ok := stats[label]
+			if !ok {
+				info = &LabelInfo{Name: label}
+				stats[label] = info
+			}
+			info.add(t)
+		}
+	}
+
+	labels := make([]LabelInfo, 0, len(stats))
+	for _, info := range stats {
+		labels = append(labels, *info)
+	}
+	sort.Slice(labels, func(i, j int) bool {
+		return strings.ToLower(labels[i].Name) < strings.ToLower(labels[j].Name)
+	})
+	return labels, unlabeled
+}
+
+func (l *LabelInfo) add(t Torrent) {
+	l.Count++
+	l.SizeBytes += t.SizeBytes
+	l.CompletedBytes += t.CompletedBytes
+	l.UploadRate += t.UploadRate
+	l.DownloadRate += t.DownloadRate
+}
+
+// Fetches d.custom1 of every torrent in the scope of the user of the context mapped by hash
+func torrentLabels(ctx context.Context, rt *Rtorrent) (map[string]string, error) {
+	commands, err := fieldCommands[Torrent]([]string{"hash", "custom1"})
+	if err != nil {
+		return nil, err
+	}
+	torrents, err := multicall[Torrent](ctx, rt, "d.multicall2", []interface{}{"", "main"}, commands)
+	if err != nil {
+		return nil, err
+	}
+
+	torrents = filterScope(torrents, scopeFromContext(ctx), rt.labelDelimiter)
+	custom1 := make(map[string]string, len(torrents))
+	for _, t := range torrents {
+		custom1[strings.ToUpper(t.Hash)] = t.Custom1
+	}
+	return custom1, nil
+}
+
+// Returns the calls changing the labels of each of the hashes. current contains
+// d.custom1 of the torrents by hash and is only used to add and remove labels.
+func labelCalls(req LabelRequest, hashes []string, current map[string]string, delimiter string) ([]SystemCall, error) {
+	switch req.Action {
+	case "set", "add", "remove":
+		if len(req.Labels) == 0 {
+			return nil, fmt.Errorf("%w: labels are required", ErrInvalidArgument)
+		}
+	case "clear":
+	default:
+		return nil, fmt.Errorf("%w: unknown label action %q", ErrInvalidArgument, req.Action)
+	}
+
+	calls := make([]SystemCall, 0, len(hashes))
+	for _, hash := range hashes {
+		var labels []string
+		switch req.Action {
+		case "set":
+			labels = req.Labels
+		case "add":
+			labels = append(parseLabels(current[hash], delimiter), req.Labels...)
+		case "remove":
+			remove := make(map[string]bool)
+			for _, label := range req.Labels {
+				remove[strings.TrimSpace(label)] = true
+			}
+			for _, label := range parseLabels(current[hash], delimiter) {
+				if !remove[label] {
+					labels = append(labels, label)
+				}
+			}
+		}
+
+		value, err := formatLabels(labels, delimiter)
+		if err != nil {
+			return nil, err
+		}
+		calls = append(calls, SystemCall{MethodName: "d.custom1.set", Params: []interface{}{hash, value}})
+	}
+	return calls, nil
+}
+
+// Returns the hashes and calls renaming a label on every torrent that has it.
+// An empty name removes the label.
+func renameCalls(from string, to string, current map[string]string, delimiter string) ([]string, []SystemCall, error) {
+	from, to = strings.TrimSpace(from), strings.TrimSpace(to)
+	if from == "" {
+		return nil, nil, fmt.Errorf("%w: from is required", ErrInvalidArgument)
+	}
+
+	hashes := make([]string, 0)
+	for hash, custom1 := range current {
+		if hasLabel(custom1, from, delimiter) {
+			hashes = append(hashes, hash)
+		}
+	}
+	sort.Strings(hashes)
+
+	calls := make([]SystemCall, 0, len(hashes))
+	for _, hash := range hashes {
+		labels := parseLabels(current[hash], delimiter)
+		for i, label := range labels {
+			if label == from {
+				labels[i] = to
+			}
+		}
+
+		value, err := formatLabels(labels, delimiter)
+		if err != nil {
+			return nil, nil, err
+		}
+		calls = append(calls, SystemCall{MethodName: "d.custom1.set", Params: []interface{}{hash, value}})
+	}
+	return hashes, calls, nil
+}
@@ -0,0 +1,431 @@
+package kahva
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/base32"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Default maximum size of a .torrent file
+const DefaultMaxTorrentSize = 10 << 20
+
+// LoadConfig contains limits for loading torrents
+type LoadConfig struct {
+	// Maximum size of a load request body in bytes
+	MaxRequestSize int64
+	// Maximum size of a single .torrent file in bytes
+	MaxTorrentSize int64
+	// Maximum number of torrents loaded concurrently
+	Parallelism int
+	// Read and write deadline of a load request, replaces the server timeouts
+	Timeout time.Duration
+	// Timeout for downloading a single .torrent file
+	FetchTimeout time.Duration
+	// Allow downloading .torrent files from loopback, private and link-local addresses
+	FetchPrivate bool
+}
+
+// Default limits for loading torrents
+var DefaultLoadConfig = LoadConfig{
+	MaxRequestSize: 64 << 20,
+	MaxTorrentSize: DefaultMaxTorrentSize,
+	Parallelism:    4,
+	Timeout:        time.Minute,
+	FetchTimeout:   30 * time.Second,
+}
+
+// Maximum number of redirects followed when downloading a .torrent file
+const maxFetchRedirects = 5
+
+// Maximum number of .torrent files read from a single zip archive
+const maxZipEntries = 1000
+
+// loadItem is a single torrent to load, either an URI or the contents of a .torrent file
+type loadItem struct {
+	// Name of the uploaded file
+	file string
+	uri  string
+	data []byte
+	// Error encountered while reading the item from the request
+	err error
+}
+
+// Loads items with bounded parallelism. Results and errors are in the same order as items,
+// failed items have their error set in both.
+func loadBatch(ctx context.Context, rt *Rtorrent, client *http.Client, items []loadItem, options LoadOptions, config LoadConfig) ([]LoadResult, []error) {
+	results := make([]LoadResult, len(items))
+	errs := make([]error, len(items))
+	parallelism := config.Parallelism
+	if parallelism < 1 {
+		parallelism = 1
+	}
+
+	sem := make(chan struct{}, parallelism)
+	var wg sync.WaitGroup
+	for i, item := range items {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, item loadItem) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			result, err := loadOne(ctx, rt, client, item, options, config)
+			if err != nil {
+				log.Error().Err(err).Str("file", item.file).Str("uri", item.uri).Msg("cant load torrent")
+				_, code := errorStatus(err)
+				result.Code = code
+				result.Error = err.Error()
+			}
+			result.File = item.file
+			result.URI = item.uri
+			results[i] = result
+			errs[i] = err
+		}(i, item)
+	}
+	wg.Wait()
+	return results, errs
+}
+
+// Loads a single item
+func loadOne(ctx context.Context, rt *Rtorrent, client *http.Client, item loadItem, options LoadOptions, config LoadConfig) (LoadResult, error) {
+	if item.err != nil {
+		return LoadResult{}, item.err
+	}
+
+	if strings.HasPrefix(item.uri, "magnet:") {
+		hash, err := magnetHash(item.uri)
+		if err != nil {
+			return LoadResult{}, err
+		}
+		err = rt.LoadContext(ctx, item.uri, options)
+		if err != nil {
+			return LoadResult{Hash: hash}, err
+		}
+		return LoadResult{Hash: hash}, nil
+	}
+
+	data := item.data
+	if item.uri != "" {
+		var err error
+		data, err = fetchTorrent(ctx, client, item.uri, config.MaxTorrentSize)
+		if err != nil {
+			return LoadResult{}, err
+		}
+	}
+
+	meta, err := ParseTorrent(data, config.MaxTorrentSize)
+	if err != nil {
+		return LoadResult{}, err
+	}
+
+	err = rt.LoadRawContext(ctx, data, options)
+	if err != nil {
+		return metaResult(meta), err
+	}
+	return metaResult(meta), nil
+}
+
+// Reads uploaded .torrent files and zip archives of .torrent files from a multipart form
+func formItems(form *multipart.Form, maxSize int64) []loadItem {
+	items := make([]loadItem, 0)
+	for _, key := range []string{"file", "files"} {
+		for _, header := range form.File[key] {
+			data, err := readFormFile(header)
+			if err != nil {
+				items = append(items, loadItem{file: header.Filename, err: err})
+				continue
+			}
+
+			if !isZip(header.Filename, data) {
+				items = append(items, loadItem{file: header.Filename, data: data})
+				continue
+			}
+
+			entries, err := zipItems(header.Filename, data, maxSize)
+			if err != nil {
+				items = append(items, loadItem{file: header.Filename, err: err})
+				continue
+			}
+			items = append(items, entries...)
+		}
+	}
+	return items
+}
+
+func readFormFile(header *multipart.FileHeader) ([]byte, error) {
+	file, err := header.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return io.ReadAll(file)
+}
+
+// Returns true if the file is a zip archive
+func isZip(name string, data []byte) bool {
+	return strings.HasSuffix(strings.ToLower(name), ".zip") || bytes.HasPrefix(data, []byte("PK\x03\x04"))
+}
+
+// Reads .torrent files from a zip archive, other entries are ignored
+func zipItems(name string, data []byte, maxSize int64) ([]loadItem, error) {
+	archive, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid zip archive: %s", ErrInvalidArgument, err)
+	}
+
+	items := make([]loadItem, 0)
+	for _, f := range archive.File {
+		if f.FileInfo().IsDir() || !strings.HasSuffix(strings.ToLower(f.Name), ".torrent") {
+			continue
+		}
+		if len(items) >= maxZipEntries {
+			return nil, fmt.Errorf("%w: zip archive contains more than %d torrents", ErrInvalidArgument, maxZipEntries)
+		}
+
+		item := loadItem{file: name + "/" + f.Name}
+		item.data, item.err = readZipFile(f, maxSize)
+		items = append(items, item)
+	}
+
+	if len(items) == 0 {
+		return nil, fmt.Errorf("%w: zip archive contains no .torrent files", ErrInvalidArgument)
+	}
+	return items, nil
+}
+
+func readZipFile(f *zip.File, maxSize int64) ([]byte, error) {
+	if f.UncompressedSize64 > uint64(maxSize) {
+		return nil, fmt.Errorf("%w: torrent is larger than %d bytes", ErrInvalidArgument, maxSize)
+	}
+
+	rc, err := f.Open()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidArgument, err)
+	}
+	defer rc.Close()
+
+	data, err := io.ReadAll(io.LimitReader(rc, maxSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidArgument, err)
+	}
+	if int64(len(data)) > maxSize {
+		return nil, fmt.Errorf("%w: torrent is larger than %d bytes", ErrInvalidArgument, maxSize)
+	}
+	return data, nil
+}
+
+// Returns a load result for a .torrent file
+func metaResult(meta TorrentMeta) LoadResult {
+	return LoadResult{
+		Hash:   meta.Hash,
+		HashV2: meta.HashV2,
+		Name:   meta.Name,
+		Size:   meta.Size,
+		Files:  meta.Files,
+	}
+}
+
+// Converts load options from a request, the label is encoded like labels set through the label API
+func loadOptions(req LoadOptionsRequest, delimiter string) (LoadOptions, error) {
+	options := LoadOptions{
+		Start:     req.Start == nil || *req.Start,
+		Directory: req.Directory,
+		Priority:  req.Priority,
+		Custom:    [5]string{req.Custom1, req.Custom2, req.Custom3, req.Custom4, req.Custom5},
+	}
+
+	if req.Label != "" {
+		label, err := formatLabels([]string{req.Label}, delimiter)
+		if err != nil {
+			return options, err
+		}
+		if req.Custom1 != "" && req.Custom1 != label {
+			return options, fmt.Errorf("%w: label and custom1 must not differ", ErrInvalidArgument)
+		}
+		options.Custom[0] = label
+	}
+	return options, nil
+}
+
+// Reads load options from multipart form values
+func loadOptionsForm(r *http.Request) (LoadOptionsRequest, error) {
+	req := LoadOptionsRequest{
+		Directory: r.FormValue("directory"),
+		Label:     r.FormValue("label"),
+		Custom1:   r.FormValue("custom1"),
+		Custom2:   r.FormValue("custom2"),
+		Custom3:   r.FormValue("custom3"),
+		Custom4:   r.FormValue("custom4"),
+		Custom5:   r.FormValue("custom5"),
+	}
+
+	if value := r.FormValue("start"); value != "" {
+		start, err := strconv.ParseBool(value)
+		if err != nil {
+			return req, fmt.Errorf("%w: start must be true or false", ErrInvalidArgument)
+		}
+		req.Start = &start
+	}
+
+	if value := r.FormValue("priority"); value != "" {
+		priority, err := strconv.Atoi(value)
+		if err != nil {
+			return req, fmt.Errorf("%w: priority must be an integer", ErrInvalidArgument)
+		}
+		req.Priority = &priority
+	}
+	return req, nil
+}
+
+// Returns the upper case hex info-hash of a magnet URI. Magnets with a
+// btih (v1) or btmh (v2) exact topic are accepted.
+func magnetHash(uri string) (string, error) {
+	u, err := url.Parse(uri)
+	if err != nil || u.Scheme != "magnet" {
+		return "", fmt.Errorf("%w: invalid magnet uri", ErrInvalidArgument)
+	}
+
+	var v2 string
+	for _, xt := range u.Query()["xt"] {
+		switch {
+		case strings.HasPrefix(xt, "urn:btih:"):
+			hash := strings.TrimPrefix(xt, "urn:btih:")
+			switch len(hash) {
+			case 40:
+				if _, err := hex.DecodeString(hash); err == nil {
+					return strings.ToUpper(hash), nil
+				}
+			case 32:
+				b, err := base32.StdEncoding.DecodeString(strings.ToUpper(hash))
+				if err == nil {
+					return strings.ToUpper(hex.EncodeToString(b)), nil
+				}
+			}
+			return "", fmt.Errorf("%w: invalid magnet btih %q", ErrInvalidArgument, hash)
+		case strings.HasPrefix(xt, "urn:btmh:"):
+			// multihash of sha2-256 (0x12 0x20), rTorrent identifies the torrent by the truncated hash
+			hash := strings.TrimPrefix(xt, "urn:btmh:")
+			b, err := hex.DecodeString(hash)
+			if err != nil || len(b) != 34 || b[0] != 0x12 || b[1] != 0x20 {
+				return "", fmt.Errorf("%w: invalid magnet btmh %q", ErrInvalidArgument, hash)
+			}
+			v2 = strings.ToUpper(hex.EncodeToString(b[2:22]))
+		}
+	}
+	if v2 != "" {
+		return v2, nil
+	}
+	return "", fmt.Errorf("%w: magnet uri has no info-hash", ErrInvalidArgument)
+}
+
+// Returns a client for downloading .torrent files. Unless private addresses are allowed
+// connections to addresses that are not public are refused after name resolution,
+// which also applies to redirects, so that clients can not probe the local network.
+func newFetchClient(config LoadConfig) *http.Client {
+	dialer := &net.Dialer{Timeout: 10 * time.Second}
+	if !config.FetchPrivate {
+		dialer.Control = func(network string, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			ip := net.ParseIP(host)
+			if ip == nil || !isPublicIP(ip) {
+				return fmt.Errorf("address %s is not public", host)
+			}
+			return nil
+		}
+	}
+
+	return &http.Client{
+		Timeout: config.FetchTimeout,
+		Transport: &http.Transport{
+			// a proxy would connect to private addresses on behalf of the client
+			Proxy:               nil,
+			DialContext:         dialer.DialContext,
+			TLSHandshakeTimeout: 10 * time.Second,
+			MaxIdleConns:        10,
+			IdleConnTimeout:     90 * time.Second,
+		},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= maxFetchRedirects {
+				return fmt.Errorf("stopped after %d redirects", maxFetchRedirects)
+			}
+			if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+				return fmt.Errorf("redirect to unsupported scheme %q", req.URL.Scheme)
+			}
+			return nil
+		},
+	}
+}
+
+// Carrier-grade NAT range, not covered by net.IP.IsPrivate
+var sharedAddressSpace = &net.IPNet{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)}
+
+// Returns true if ip is a global unicast address outside of private ranges
+func isPublicIP(ip net.IP) bool {
+	return ip.IsGlobalUnicast() && !ip.IsPrivate() && !sharedAddressSpace.Contains(ip)
+}
+
+// Downloads a .torrent file over HTTP(S). Responses larger than maxSize
+// or that do not look like a .torrent file are rejected.
+func fetchTorrent(ctx context.Context, client *http.Client, uri string, maxSize int64) ([]byte, error) {
+	u, err := url.Parse(uri)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("%w: invalid torrent url", ErrInvalidArgument)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/x-bittorrent")
+
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%w: cant fetch torrent: %s", ErrInvalidArgument, err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%w: cant fetch torrent: bad status code %d", ErrInvalidArgument, res.StatusCode)
+	}
+	if res.ContentLength > maxSize {
+		return nil, fmt.Errorf("%w: torrent is larger than %d bytes", ErrInvalidArgument, maxSize)
+	}
+
+	ct, _, _ := mime.ParseMediaType(res.Header.Get("Content-Type"))
+	switch ct {
+	case "", "application/x-bittorrent", "application/octet-stream", "application/force-download":
+	default:
+		return nil, fmt.Errorf("%w: unexpected content type %q", ErrInvalidArgument, ct)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(res.Body, maxSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("%w: cant fetch torrent: %s", ErrInvalidArgument, err)
+	}
+	if int64(len(data)) > maxSize {
+		return nil, fmt.Errorf("%w: torrent is larger than %d bytes", ErrInvalidArgument, maxSize)
+	}
+	if len(data) == 0 || data[0] != 'd' {
+		return nil, fmt.Errorf("%w: response is not a torrent file", ErrInvalidArgument)
+	}
+	return data, nil
+}
@@ -0,0 +1,402 @@
+package kahva
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Move job states
+const (
+	MoveStateRunning = "running"
+	MoveStateDone    = "done"
+	MoveStateFailed  = "failed"
+)
+
+// Finished move jobs are kept this long so that clients can poll the result
+const moveJobRetention = 24 * time.Hour
+
+// MoveConfig controls moving torrent data. Data is moved by kahva and can only be
+// moved between download roots which are mapped to local paths like in EraseConfig.
+type MoveConfig struct {
+	Roots []EraseRoot
+}
+
+// MoveJob is the progress of moving the data of a torrent
+type MoveJob struct {
+	ID        string `json:"id"`
+	Hash      string `json:"hash"`
+	Directory string `json:"directory"`
+	State     string `json:"state"`
+	// Bytes copied so far, moves within a filesystem complete without copying
+	BytesDone  int64     `json:"bytes_done"`
+	BytesTotal int64     `json:"bytes_total"`
+	Error      string    `json:"error,omitempty"`
+	StartedAt  time.Time `json:"started_at"`
+	FinishedAt time.Time `json:"finished_at"`
+}
+
+// MoveJobs runs moves in the background and keeps their progress
+type MoveJobs struct {
+	ctx    context.Context
+	rt     *Rtorrent
+	config MoveConfig
+
+	mu   sync.Mutex
+	jobs map[string]*moveJob
+}
+
+type moveJob struct {
+	MoveJob
+	// progress is updated without holding the lock
+	done atomic.Int64
+}
+
+// Creates a new instance of MoveJobs. Running moves are cancelled when the context is cancelled.
+func NewMoveJobs(ctx context.Context, rt *Rtorrent, config MoveConfig) *MoveJobs {
+	return &MoveJobs{
+		ctx:    ctx,
+		rt:     rt,
+		config: config,
+		jobs:   make(map[string]*moveJob),
+	}
+}
+
+// Starts moving the data of a torrent into directory and returns the job
+func (m *MoveJobs) Start(hash string, directory string) (MoveJob, error) {
+	if !filepath.IsAbs(directory) {
+		return MoveJob{}, fmt.Errorf("%w: directory must be an absolute path", ErrInvalidArgument)
+	}
+	id, err := moveJobID()
+	if err != nil {
+		return MoveJob{}, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for key, job := range m.jobs {
+		if job.State != MoveStateRunning && time.Since(job.FinishedAt) > moveJobRetention {
+			delete(m.jobs, key)
+			continue
+		}
+		if job.State == MoveStateRunning && job.Hash == hash {
+			return MoveJob{}, fmt.Errorf("%w: torrent is already being moved by job %s", ErrInvalidArgument, job.ID)
+		}
+	}
+
+	job := &moveJob{MoveJob: MoveJob{
+		ID:        id,
+		Hash:      hash,
+		Directory: filepath.Clean(directory),
+		State:     MoveStateRunning,
+		StartedAt: time.Now(),
+	}}
+	m.jobs[id] = job
+
+	go m.run(job)
+	return job.MoveJob, nil
+}
+
+// Returns the job with the ID
+func (m *MoveJobs) Get(id string) (MoveJob, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	job, ok := m.jobs[id]
+	if !ok {
+		return MoveJob{}, false
+	}
+	snapshot := job.MoveJob
+	snapshot.BytesDone = job.done.Load()
+	return snapshot, true
+}
+
+func (m *MoveJobs) run(job *moveJob) {
+	err := m.move(job)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	job.FinishedAt = time.Now()
+	job.State = MoveStateDone
+	if err != nil {
+		job.State = MoveStateFailed
+		job.Error = err.Error()
+		log.Error().Err(err).Str("hash", job.Hash).Str("directory", job.Directory).Msg("cant move torrent")
+		return
+	}
+	log.Info().Str("hash", job.Hash).Str("directory", job.Directory).Msg("moved torrent")
+}
+
+type moveTorrent struct {
+	Name      string
+	Directory string
+	MultiFile int64
+	State     int64
+	IsActive  int64
+}
+
+// Moves the data of a torrent. rTorrent is only pointed at the new directory once all
+// data is in place and the old data is only deleted after that, so a failed move leaves
+// the torrent in its old location.
+func (m *MoveJobs) move(job *moveJob) error {
+	ctx := m.ctx
+	hash := job.Hash
+	paths := EraseConfig{Mode: EraseModeLocal, Roots: m.config.Roots}
+
+	var t moveTorrent
+	for _, field := range []struct {
+		method string
+		dst    interface{}
+	}{
+		{"d.name", &t.Name},
+		{"d.directory", &t.Directory},
+		{"d.is_multi_file", &t.MultiFile},
+		{"d.state", &t.State},
+		{"d.is_active", &t.IsActive},
+	} {
+		err := m.rt.call(ctx, field.method, hash, field.dst)
+		if err != nil {
+			return err
+		}
+	}
+
+	// rTorrent appends the torrent name to the directory of multi file torrents
+	source := filepath.Clean(t.Directory)
+	target := filepath.Join(job.Directory, t.Name)
+	if t.MultiFile == 0 {
+		files, err := Multicall[eraseFile](ctx, m.rt, "f.multicall", hash, "")
+		if err != nil {
+			return err
+		}
+		if len(files) != 1 {
+			return fmt.Errorf("expected a single file, got %d", len(files))
+		}
+		source = filepath.Join(t.Directory, files[0].Path)
+		target = filepath.Join(job.Directory, files[0].Path)
+	}
+	if source == target {
+		return fmt.Errorf("%w: torrent is already in %s", ErrInvalidArgument, job.Directory)
+	}
+
+	err := paths.check([]string{source, target})
+	if err != nil {
+		return err
+	}
+	localSource, err := paths.local(source)
+	if err != nil {
+		return err
+	}
+	localTarget, err := paths.local(target)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Lstat(localTarget); !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("%w: %s already exists", ErrInvalidArgument, target)
+	}
+
+	total, err := treeSize(localSource)
+	if err != nil {
+		return err
+	}
+	m.mu.Lock()
+	job.BytesTotal = total
+	m.mu.Unlock()
+
+	// the torrent has to be closed to change its directory
+	defer m.restore(hash, t)
+	for _, method := range []string{"d.stop", "d.close"} {
+		err := m.rt.call(ctx, method, hash, nil)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = os.MkdirAll(filepath.Dir(localTarget), 0o755)
+	if err != nil {
+		return err
+	}
+	// the directories may have been replaced with symlinks in the meantime
+	err = paths.check([]string{target})
+	if err != nil {
+		return err
+	}
+
+	err = os.Rename(localSource, localTarget)
+	renamed := err == nil
+	if errors.Is(err, syscall.EXDEV) {
+		err = copyAtomic(ctx, localSource, localTarget, &job.done)
+	}
+	if err != nil {
+		return err
+	}
+	if renamed {
+		job.done.Store(total)
+	}
+
+	err = m.rt.call(ctx, "d.directory.set", []interface{}{hash, job.Directory}, nil)
+	if err != nil {
+		// put the data back where rTorrent expects it
+		if renamed {
+			return errors.Join(err, os.Rename(localTarget, localSource))
+		}
+		return errors.Join(err, os.RemoveAll(localTarget))
+	}
+
+	if !renamed {
+		return os.RemoveAll(localSource)
+	}
+	return nil
+}
+
+// Restarts the torrent if it was started before the move
+func (m *MoveJobs) restore(hash string, t moveTorrent) {
+	if t.State == 0 {
+		return
+	}
+	// the job context may already be cancelled
+	ctx := context.WithoutCancel(m.ctx)
+	methods := []string{"d.start"}
+	if t.IsActive == 0 {
+		methods = append(methods, "d.pause")
+	}
+	for _, method := range methods {
+		err := m.rt.call(ctx, method, hash, nil)
+		if err != nil {
+			log.Error().Err(err).Str("hash", hash).Msg("cant restart torrent after move")
+			return
+		}
+	}
+}
+
+// Copies src to dst through a temporary path next to dst which is renamed once the
+// copy is complete. The temporary path is removed if the copy fails.
+func copyAtomic(ctx context.Context, src string, dst string, done *atomic.Int64) error {
+	tmp := filepath.Join(filepath.Dir(dst), ".kahva-move-"+filepath.Base(dst))
+	err := os.RemoveAll(tmp)
+	if err != nil {
+		return err
+	}
+
+	err = copyTree(ctx, src, tmp, done)
+	if err == nil {
+		err = os.Rename(tmp, dst)
+	}
+	if err != nil {
+		return errors.Join(err, os.RemoveAll(tmp))
+	}
+	return nil
+}
+
+// Copies a file or directory recursively keeping modes, modification times and symlinks
+func copyTree(ctx context.Context, src string, dst string, done *atomic.Int64) error {
+	return filepath.WalkDir(src, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case d.IsDir():
+			return os.MkdirAll(target, info.Mode().Perm())
+		case d.Type()&fs.ModeSymlink != 0:
+			link, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			return os.Symlink(link, target)
+		case d.Type().IsRegular():
+			err := copyFile(path, target, info.Mode().Perm(), done)
+			if err != nil {
+				return err
+			}
+			return os.Chtimes(target, info.ModTime(), info.ModTime())
+		}
+		return fmt.Errorf("cant move special file %s", path)
+	})
+}
+
+func copyFile(src string, dst string, perm fs.FileMode, done *atomic.Int64) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, perm)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(out, &countingReader{r: in, n: done})
+	if err == nil {
+		err = out.Sync()
+	}
+	return errors.Join(err, out.Close())
+}
+
+// countingReader adds the number of bytes read to n
+type countingReader struct {
+	r io.Reader
+	n *atomic.Int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n.Add(int64(n))
+	return n, err
+}
+
+// Returns the total size of regular files in a file or directory
+func treeSize(path string) (int64, error) {
+	var total int64
+	err := filepath.WalkDir(path, func(_ string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		total += info.Size()
+		return nil
+	})
+	return total, err
+}
+
+// Returns a random job ID
+func moveJobID() (string, error) {
+	b := make([]byte, 16)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
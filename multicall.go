@@ -0,0 +1,91 @@
+package kahva
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+)
+
+// Returns the multicall commands declared in the rt tags of T in field order
+func MulticallCommands[T any]() []string {
+	t := reflect.TypeOf(*new(T))
+	if t == nil || t.Kind() != reflect.Struct {
+		return nil
+	}
+
+	commands := make([]string, 0, t.NumField())
+	seen := make(map[string]bool)
+	for i := 0; i < t.NumField(); i++ {
+		tag := t.Field(i).Tag.Get("rt")
+		if tag == "" || !t.Field(i).IsExported() || seen[tag] {
+			continue
+		}
+		seen[tag] = true
+		commands = append(commands, tag)
+	}
+	return commands
+}
+
+// Calls a multicall method (d.multicall2, f.multicall, p.multicall, t.multicall) with params
+// followed by the commands from the rt tags of T and decodes the result into a slice of T.
+//
+//	torrents, err := Multicall[Torrent](ctx, rt, "d.multicall2", "", "main")
+//	files, err := Multicall[File](ctx, rt, "f.multicall", hash, "")
+func Multicall[T any](ctx context.Context, rt *Rtorrent, method string, params ...interface{}) ([]T, error) {
+	commands := MulticallCommands[T]()
+	if len(commands) == 0 {
+		return nil, fmt.Errorf("%w: %T has no rt tags", ErrInvalidArgument, *new(T))
+	}
+	return multicall[T](ctx, rt, method, params, commands)
+}
+
+// Calls a multicall method with params followed by commands and decodes the result into a slice of T
+func multicall[T any](ctx context.Context, rt *Rtorrent, method string, params []interface{}, commands []string) ([]T, error) {
+	args := make([]interface{}, 0, len(params)+len(commands))
+	args = append(args, params...)
+	for _, command := range commands {
+		args = append(args, command)
+	}
+
+	var result interface{}
+	err := rt.call(ctx, method, args, &result)
+	if err != nil {
+		return nil, err
+	}
+
+	items, err := multicallTags[T](result, commands)
+	err = decodeError(method, err)
+	if err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+// Calls a per torrent multicall method (f.multicall, p.multicall, t.multicall) for many
+// torrents in a single system.multicall. Torrents that could not be queried have their error set.
+func batchMulticall[T any](ctx context.Context, rt *Rtorrent, method string, hashes []string) ([][]T, []error, error) {
+	commands := MulticallCommands[T]()
+	calls := make([]SystemCall, 0, len(hashes))
+	for _, hash := range hashes {
+		params := []interface{}{hash, ""}
+		for _, command := range commands {
+			params = append(params, command)
+		}
+		calls = append(calls, SystemCall{MethodName: method, Params: params})
+	}
+
+	values, errs, err := rt.batch(ctx, calls)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	items := make([][]T, len(hashes))
+	for i := range hashes {
+		if errs[i] != nil {
+			continue
+		}
+		items[i], err = multicallTags[T](values[i], commands)
+		errs[i] = decodeError(method, err)
+	}
+	return items, errs, nil
+}
@@ -0,0 +1,142 @@
+package kahva
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Returns the calls performing a peer action on peers of a torrent by peer ID
+func peerCalls(hash string, req PeerRequest) ([]SystemCall, error) {
+	if len(req.IDs) == 0 {
+		return nil, fmt.Errorf("%w: ids are required", ErrInvalidArgument)
+	}
+
+	calls := make([]SystemCall, 0, len(req.IDs)*2)
+	for _, id := range req.IDs {
+		target := peerTarget(hash, id)
+		switch req.Action {
+		case "disconnect":
+			calls = append(calls, SystemCall{MethodName: "p.disconnect", Params: []interface{}{target}})
+		case "ban":
+			// banning does not close an existing connection
+			calls = append(calls,
+				SystemCall{MethodName: "p.banned.set", Params: []interface{}{target, 1}},
+				SystemCall{MethodName: "p.disconnect", Params: []interface{}{target}},
+			)
+		case "snub":
+			calls = append(calls, SystemCall{MethodName: "p.snubbed.set", Params: []interface{}{target, 1}})
+		case "unsnub":
+			calls = append(calls, SystemCall{MethodName: "p.snubbed.set", Params: []interface{}{target, 0}})
+		default:
+			return nil, fmt.Errorf("%w: unknown peer action %q", ErrInvalidArgument, req.Action)
+		}
+	}
+	return calls, nil
+}
+
+// Returns the target of a peer command, the peer ID is the hex encoded p.id
+func peerTarget(hash string, id string) string {
+	return fmt.Sprintf("%s:p%s", hash, id)
+}
+
+type banPeer struct {
+	ID            string `rt:"p.id="`
+	ClientVersion string `rt:"p.client_version="`
+	Banned        int64  `rt:"p.banned="`
+}
+
+type banTorrent struct {
+	Hash     string `rt:"d.hash="`
+	IsActive int64  `rt:"d.is_active="`
+}
+
+// PeerBanner bans and disconnects peers whose client version matches a regular
+// expression. Peers of active torrents are checked once per interval.
+type PeerBanner struct {
+	rt       *Rtorrent
+	client   *regexp.Regexp
+	interval time.Duration
+}
+
+// Creates a new instance of PeerBanner
+func NewPeerBanner(rt *Rtorrent, client *regexp.Regexp, interval time.Duration) *PeerBanner {
+	return &PeerBanner{
+		rt:       rt,
+		client:   client,
+		interval: interval,
+	}
+}
+
+// Polls rTorrent until the context is cancelled
+func (b *PeerBanner) Run(ctx context.Context) {
+	ticker := time.NewTicker(b.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			err := b.poll(ctx)
+			if err != nil {
+				log.Error().Err(err).Msg("cant poll peers for bans")
+			}
+		}
+	}
+}
+
+func (b *PeerBanner) poll(ctx context.Context) error {
+	torrents, err := Multicall[banTorrent](ctx, b.rt, "d.multicall2", "", "main")
+	if err != nil {
+		return err
+	}
+
+	hashes := make([]string, 0)
+	for _, t := range torrents {
+		if t.IsActive == 1 {
+			hashes = append(hashes, t.Hash)
+		}
+	}
+	if len(hashes) == 0 {
+		return nil
+	}
+
+	peers, errs, err := batchMulticall[banPeer](ctx, b.rt, "p.multicall", hashes)
+	if err != nil {
+		return err
+	}
+
+	calls := make([]SystemCall, 0)
+	for i, hash := range hashes {
+		// torrents can be erased between the two calls
+		if errs[i] != nil {
+			continue
+		}
+		for _, p := range peers[i] {
+			if p.Banned != 0 || !b.client.MatchString(p.ClientVersion) {
+				continue
+			}
+			log.Info().Str("hash", hash).Str("peer", p.ID).Str("client_version", p.ClientVersion).Msg("banning peer")
+			ban, _ := peerCalls(hash, PeerRequest{Action: "ban", IDs: []string{p.ID}})
+			calls = append(calls, ban...)
+		}
+	}
+	if len(calls) == 0 {
+		return nil
+	}
+
+	errs, err = b.rt.BatchContext(ctx, calls)
+	if err != nil {
+		return err
+	}
+	for i, err := range errs {
+		if err != nil {
+			log.Error().Err(err).Str("method", calls[i].MethodName).Msg("cant ban peer")
+		}
+	}
+	return nil
+}
@@ -4,7 +4,121 @@ type TorrentPriorityRequest struct {
 	Priority int `json:"priority"`
 }
 
+// TorrentUpdateRequest changes the fields of a torrent which are set
+type TorrentUpdateRequest struct {
+	Priority *int    `json:"priority"`
+	Label    *string `json:"label"`
+	// Directory rTorrent looks for the data in, the data is not moved
+	Directory *string `json:"directory"`
+}
+
 type ThrottleRequest struct {
 	Type      string `json:"type"`
 	Kilobytes int    `json:"kilobytes"`
 }
+
+// LoadRequest loads torrents from magnet links or HTTP(S) URLs of .torrent files
+type LoadRequest struct {
+	URIs []string `json:"uris"`
+	LoadOptionsRequest
+}
+
+// LoadOptionsRequest contains options applied to loaded torrents
+type LoadOptionsRequest struct {
+	// Start the torrents after loading, defaults to true
+	Start     *bool  `json:"start"`
+	Directory string `json:"directory"`
+	// Alias for custom1
+	Label    string `json:"label"`
+	Priority *int   `json:"priority"`
+	Custom1  string `json:"custom1"`
+	Custom2  string `json:"custom2"`
+	Custom3  string `json:"custom3"`
+	Custom4  string `json:"custom4"`
+	Custom5  string `json:"custom5"`
+}
+
+// ActionRequest performs one action on many torrents, selected either by hash
+// or by a filter over a view using the view query parameters (e.g. "state=stopped&label=tv")
+type ActionRequest struct {
+	Hashes []string `json:"hashes"`
+	View   string   `json:"view"`
+	Filter string   `json:"filter"`
+	// One of start, stop, pause, resume, hash, erase, priority or label
+	Action   string  `json:"action"`
+	Priority *int    `json:"priority"`
+	Label    *string `json:"label"`
+}
+
+// LabelRequest changes the labels of torrents selected like in ActionRequest
+type LabelRequest struct {
+	Hashes []string `json:"hashes"`
+	View   string   `json:"view"`
+	Filter string   `json:"filter"`
+	// One of set, add, remove or clear
+	Action string   `json:"action"`
+	Labels []string `json:"labels"`
+}
+
+// LabelRenameRequest renames a label on all torrents, an empty to removes it
+type LabelRenameRequest struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// FilePriorityRequest sets the priority of files selected by index or glob pattern
+type FilePriorityRequest struct {
+	// 0 (off), 1 (normal) or 2 (high), defaults to normal
+	Priority *int `json:"priority"`
+	// File indexes or inclusive ranges, e.g. "3" or "0-5"
+	Indexes  []string `json:"indexes"`
+	Patterns []string `json:"patterns"`
+	// Turn off every file that is not selected
+	Only bool `json:"only"`
+}
+
+// TrackerRequest changes the trackers of a torrent
+type TrackerRequest struct {
+	// One of enable, disable, remove, add or announce
+	Action string `json:"action"`
+	// Tracker indexes for enable, disable and remove
+	Indexes []int `json:"indexes"`
+	// Announce URL for add
+	URL string `json:"url"`
+	// Tracker group for add, defaults to a new group after the existing ones
+	Group *int `json:"group"`
+}
+
+// TrackerReplaceRequest replaces the prefix of announce URLs on torrents selected like in ActionRequest
+type TrackerReplaceRequest struct {
+	Hashes []string `json:"hashes"`
+	View   string   `json:"view"`
+	Filter string   `json:"filter"`
+	From   string   `json:"from"`
+	To     string   `json:"to"`
+	DryRun bool     `json:"dry_run"`
+}
+
+// PeerRequest performs an action on peers of a torrent
+type PeerRequest struct {
+	// One of disconnect, ban, snub or unsnub
+	Action string `json:"action"`
+	// Peer IDs as listed by the peers action
+	IDs []string `json:"ids"`
+}
+
+// MoveRequest moves the data of a torrent
+type MoveRequest struct {
+	// Absolute directory the data is moved into
+	Directory string `json:"directory"`
+}
+
+type LoginRequest struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+// TokenRequest creates an API token for the authenticated user
+type TokenRequest struct {
+	Name string `json:"name"`
+}
@@ -7,19 +7,141 @@ type Response struct {
 
 type ErrorResponse struct {
 	Status  string `json:"status"`
+	Code    string `json:"code"`
 	Message string `json:"message"`
 }
 
+// LoadResult contains the info-hash of a loaded torrent. Metadata is only
+// available for .torrent files, not for magnet links.
+type LoadResult struct {
+	// Name of the uploaded file, archive entries are prefixed with the archive name
+	File   string     `json:"file,omitempty"`
+	URI    string     `json:"uri,omitempty"`
+	Hash   string     `json:"hash"`
+	HashV2 string     `json:"hash_v2,omitempty"`
+	Name   string     `json:"name,omitempty"`
+	Size   int64      `json:"size,omitempty"`
+	Files  []MetaFile `json:"files,omitempty"`
+	// Error code and message if the torrent could not be loaded
+	Code  string `json:"code,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+type LoadResponse struct {
+	Status   string       `json:"status"`
+	Torrents []LoadResult `json:"torrents"`
+}
+
+type ActionResult struct {
+	Hash   string `json:"hash"`
+	Status string `json:"status"`
+	Code   string `json:"code,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+type ActionResponse struct {
+	Status  string         `json:"status"`
+	Results []ActionResult `json:"results"`
+}
+
+// LabelInfo contains the number of torrents with a label and their totals
+type LabelInfo struct {
+	Name           string `json:"name"`
+	Count          int    `json:"count"`
+	SizeBytes      int64  `json:"size_bytes"`
+	CompletedBytes int64  `json:"completed_bytes"`
+	UploadRate     int64  `json:"upload_rate"`
+	DownloadRate   int64  `json:"download_rate"`
+}
+
+type LabelsResponse struct {
+	Status string      `json:"status"`
+	Labels []LabelInfo `json:"labels"`
+	// Totals of torrents without labels
+	Unlabeled LabelInfo `json:"unlabeled"`
+}
+
+// TrackerChange is an announce URL replaced on a torrent
+type TrackerChange struct {
+	Index int    `json:"index"`
+	From  string `json:"from"`
+	To    string `json:"to"`
+}
+
+type TrackerReplaceResult struct {
+	Hash    string          `json:"hash"`
+	Status  string          `json:"status"`
+	Changes []TrackerChange `json:"changes"`
+	Code    string          `json:"code,omitempty"`
+	Error   string          `json:"error,omitempty"`
+}
+
+type TrackerReplaceResponse struct {
+	Status string `json:"status"`
+	DryRun bool   `json:"dry_run"`
+	// Torrents with at least one matching tracker
+	Torrents []TrackerReplaceResult `json:"torrents"`
+}
+
+type MoveJobResponse struct {
+	Status string  `json:"status"`
+	Job    MoveJob `json:"job"`
+}
+
+type UserResponse struct {
+	Status string `json:"status"`
+	User   User   `json:"user"`
+}
+
+type TokensResponse struct {
+	Status string      `json:"status"`
+	Tokens []TokenInfo `json:"tokens"`
+}
+
+// TokenResponse contains a new API token, it can not be retrieved later
+type TokenResponse struct {
+	Status string `json:"status"`
+	Name   string `json:"name"`
+	Token  string `json:"token"`
+}
+
+// AuditResponse contains audit log entries, newest first
+type AuditResponse struct {
+	Status  string       `json:"status"`
+	Entries []AuditEntry `json:"entries"`
+}
+
+type EraseResponse struct {
+	Status string `json:"status"`
+	DryRun bool   `json:"dry_run"`
+	// Deleted paths as seen by rTorrent, or the paths that would be deleted in a dry run
+	Paths []string `json:"paths"`
+}
+
 type ViewResponse struct {
-	Status   string    `json:"status"`
+	Status string `json:"status"`
+	// Number of torrents matching the filters before pagination
+	Total    int       `json:"total"`
 	Torrents []Torrent `json:"torrents"`
 }
 
+// ViewFieldsResponse contains torrents with only the fields requested by the client
+type ViewFieldsResponse struct {
+	Status   string                   `json:"status"`
+	Total    int                      `json:"total"`
+	Torrents []map[string]interface{} `json:"torrents"`
+}
+
 type SystemResponse struct {
 	Status string `json:"status"`
 	System System `json:"system"`
 }
 
+type TorrentResponse struct {
+	Status  string  `json:"status"`
+	Torrent Torrent `json:"torrent"`
+}
+
 type FilesResponse struct {
 	Status string `json:"status"`
 	Files  []File `json:"files"`
@@ -34,3 +156,16 @@ type TrackersResponse struct {
 	Status   string    `json:"status"`
 	Trackers []Tracker `json:"trackers"`
 }
+
+// EventSnapshot is sent to new subscribers and contains every torrent in the view
+type EventSnapshot struct {
+	Torrents []Torrent `json:"torrents"`
+}
+
+// EventDelta contains changes since the previous poll. Changed torrents only contain
+// the hash and the fields that changed.
+type EventDelta struct {
+	Added   []Torrent                `json:"added"`
+	Removed []string                 `json:"removed"`
+	Changed []map[string]interface{} `json:"changed"`
+}
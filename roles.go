@@ -0,0 +1,98 @@
+package kahva
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+// ErrForbidden is returned when the role of a user does not allow a request
+var ErrForbidden = errors.New("forbidden")
+
+// Roles of users, each role includes the permissions of the previous ones
+const (
+	// Views, system details, files, peers and trackers
+	RoleReadOnly = "read-only"
+	// Loading torrents and changing their state, priority, labels, files, peers and trackers
+	RoleOperator = "operator"
+	// Erasing and moving torrents, global throttles and settings
+	RoleAdmin = "admin"
+)
+
+var roleLevels = map[string]int{
+	RoleReadOnly: 1,
+	RoleOperator: 2,
+	RoleAdmin:    3,
+}
+
+// Roles required for the actions of TorrentHandler
+var TorrentActionRoles = map[string]string{
+	"files":    RoleReadOnly,
+	"peers":    RoleReadOnly,
+	"trackers": RoleReadOnly,
+	"start":    RoleOperator,
+	"stop":     RoleOperator,
+	"pause":    RoleOperator,
+	"resume":   RoleOperator,
+	"hash":     RoleOperator,
+	"priority": RoleOperator,
+	"erase":    RoleAdmin,
+}
+
+// Returns true if a request changes state. Torrent actions still accept GET requests,
+// actions read-only users are allowed to perform do not change anything.
+func mutating(r *http.Request) bool {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		role, ok := TorrentActionRoles[mux.Vars(r)["action"]]
+		return ok && role != RoleReadOnly
+	}
+	return true
+}
+
+// Returns true if the user has at least the role
+func (u User) Can(role string) bool {
+	return roleLevels[u.Role] >= roleLevels[role]
+}
+
+// Returns an error if the request is authenticated and the user does not have the role.
+// Requests are not checked if authentication is disabled.
+func checkRole(r *http.Request, role string) error {
+	user, ok := UserFromContext(r.Context())
+	if !ok || user.Can(role) {
+		return nil
+	}
+	return fmt.Errorf("%w: %s role is required", ErrForbidden, role)
+}
+
+// Rejects requests of users without the role
+func RequireRole(role string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		err := checkRole(r, role)
+		if err != nil {
+			respondError(err, w)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Rejects requests of users without the role required for the action route variable.
+// Actions without a role are not found for every user.
+func RequireActionRole(roles map[string]string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		action := mux.Vars(r)["action"]
+		role, ok := roles[action]
+		if !ok {
+			respond(ErrorResponse{
+				Status:  "error",
+				Code:    ErrorCodeNotFound,
+				Message: "unknown action " + action,
+			}, http.StatusNotFound, w)
+			return
+		}
+		RequireRole(role, next).ServeHTTP(w, r)
+	})
+}
@@ -1,11 +1,18 @@
 package kahva
 
 import (
+	"context"
 	"encoding/base64"
 	"errors"
+	"fmt"
+	"io"
 	"net/http"
+	"net/url"
 	"reflect"
+	"sort"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/kolo/xmlrpc"
 )
@@ -70,7 +77,7 @@ type Tracker struct {
 	ActivityTimeLast int64  `rt:"t.activity_time_last=" json:"activity_time_last"`
 	ActivityTimeNext int64  `rt:"t.activity_time_next=" json:"activity_time_next"`
 	CanScrape        int64  `rt:"t.can_scrape=" json:"can_scrape"`
-	IsUsable         int64  `rt:"t.is_usable=" json:"t.is_usable"`
+	IsUsable         int64  `rt:"t.is_usable=" json:"is_usable"`
 	IsEnabled        int64  `rt:"t.is_enabled=" json:"is_enabled"`
 	FailedCounter    int64  `rt:"t.failed_counter=" json:"failed_counter"`
 	FailedTimeLast   int64  `rt:"t.failed_time_last=" json:"failed_time_last"`
@@ -80,6 +87,7 @@ type Tracker struct {
 	IsOpen           int64  `rt:"t.is_open=" json:"is_open"`
 	Type             int64  `rt:"t.type=" json:"type"`
 	URL              string `rt:"t.url=" json:"url"`
+	Group            int64  `rt:"t.group=" json:"group"`
 }
 
 type System struct {
@@ -105,40 +113,178 @@ type SystemCall struct {
 }
 
 type Config struct {
+	// XMLRPC endpoint. http(s):// URLs are used through the transport,
+	// scgi://host:port and scgi+unix:///path/to/socket talk to rTorrent directly.
 	URL       string
 	Transport http.RoundTripper
+	// Default timeout for a single XMLRPC call, zero disables the timeout.
+	// Deadlines set on the context passed to the client take precedence if they are shorter.
+	Timeout time.Duration
+	// Optional cache for read only calls, nil disables caching
+	Cache *CacheConfig
+	// Delimiter between multiple labels in d.custom1, custom1 is a single label if empty
+	LabelDelimiter string
 }
 
 type Rtorrent struct {
-	client *xmlrpc.Client
+	url     string
+	client  *http.Client
+	timeout time.Duration
+	cache   *cache
+
+	labelDelimiter string
 }
 
 // Creates a new instance of Rtorrent client
 func NewRtorrent(config Config) (*Rtorrent, error) {
-	xmlrpcClient, err := xmlrpc.NewClient(config.URL, config.Transport)
+	u, err := url.Parse(config.URL)
 	if err != nil {
 		return nil, err
 	}
 
+	// labels are URL encoded, a percent sign would make the delimiter ambiguous
+	if strings.Contains(config.LabelDelimiter, "%") {
+		return nil, fmt.Errorf("%w: label delimiter must not contain %%", ErrInvalidArgument)
+	}
+
+	transport := config.Transport
+	if isSCGI(u) {
+		transport, err = newSCGITransport(u)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+
 	rtorrent := &Rtorrent{
-		client: xmlrpcClient,
+		url:     u.String(),
+		client:  &http.Client{Transport: transport},
+		timeout: config.Timeout,
+
+		labelDelimiter: config.LabelDelimiter,
+	}
+	if config.Cache != nil {
+		rtorrent.cache = newCache(*config.Cache)
 	}
 	return rtorrent, nil
 }
 
-// Closes the underlying XMLRPC client.
+// Closes idle connections of the underlying transport.
 func (rt *Rtorrent) Close() error {
-	err := rt.client.Close()
+	rt.client.CloseIdleConnections()
+	return nil
+}
+
+// Performs a single XMLRPC call and unmarshals the result to reply if it is not nil.
+// Read only calls are served from the cache if it is enabled, mutating calls invalidate it.
+func (rt *Rtorrent) call(ctx context.Context, method string, args interface{}, reply interface{}) error {
+	var response xmlrpc.Response
+	var err error
+	if rt.cache == nil {
+		response, err = rt.roundTrip(ctx, method, args)
+	} else {
+		response, err = rt.cachedRoundTrip(ctx, method, args)
+	}
 	if err != nil {
 		return err
 	}
+
+	if reply == nil {
+		return nil
+	}
+
+	err = response.Unmarshal(reply)
+	if err != nil {
+		return &DecodeError{Method: method, Err: err}
+	}
 	return nil
 }
 
+func (rt *Rtorrent) cachedRoundTrip(ctx context.Context, method string, args interface{}) (xmlrpc.Response, error) {
+	params, ok := args.([]interface{})
+	if !ok && args != nil {
+		params = []interface{}{args}
+	}
+
+	if isMutatingCall(method, params) {
+		defer rt.cache.invalidate(callHashes(params))
+		return rt.roundTrip(ctx, method, args)
+	}
+
+	kind, hash := cacheKindOf(method, params)
+	if kind == cacheNone {
+		return rt.roundTrip(ctx, method, args)
+	}
+	if rt.cache.ttl(kind) <= 0 {
+		return rt.roundTrip(ctx, method, args)
+	}
+
+	key, err := xmlrpc.EncodeMethodCall(method, params...)
+	if err != nil {
+		return nil, err
+	}
+
+	response, modified, err := rt.cache.get(ctx, kind, string(key), hash, func(ctx context.Context) (xmlrpc.Response, error) {
+		return rt.roundTrip(ctx, method, args)
+	})
+	if err != nil {
+		return nil, err
+	}
+	recordModified(ctx, modified)
+	return response, nil
+}
+
+// Sends a XMLRPC request and returns the response if it is not a fault
+func (rt *Rtorrent) roundTrip(ctx context.Context, method string, args interface{}) (xmlrpc.Response, error) {
+	if rt.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, rt.timeout)
+		defer cancel()
+	}
+
+	req, err := xmlrpc.NewRequest(rt.url, method, args)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := rt.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, &ConnectionError{Method: method, Err: err}
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return nil, &ConnectionError{Method: method, StatusCode: res.StatusCode}
+	}
+
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, &ConnectionError{Method: method, Err: err}
+	}
+
+	response := xmlrpc.Response(body)
+	err = response.Err()
+	if err != nil {
+		var fault xmlrpc.FaultError
+		if errors.As(err, &fault) {
+			return nil, &FaultError{Method: method, Code: fault.Code, String: fault.String}
+		}
+		return nil, &DecodeError{Method: method, Err: err}
+	}
+	return response, nil
+}
+
 // Lists available XMLRPC methods
 func (rt *Rtorrent) ListMethods() ([]string, error) {
+	return rt.ListMethodsContext(context.Background())
+}
+
+// Lists available XMLRPC methods
+func (rt *Rtorrent) ListMethodsContext(ctx context.Context) ([]string, error) {
 	var result []string
-	err := rt.client.Call("system.listMethods", nil, &result)
+	err := rt.call(ctx, "system.listMethods", nil, &result)
 	if err != nil {
 		return nil, err
 	}
@@ -147,9 +293,109 @@ func (rt *Rtorrent) ListMethods() ([]string, error) {
 
 // Load and start a torrent
 func (rt *Rtorrent) LoadRawStart(file []byte) error {
+	return rt.LoadRawStartContext(context.Background(), file)
+}
+
+// Load and start a torrent
+func (rt *Rtorrent) LoadRawStartContext(ctx context.Context, file []byte) error {
 	base64 := base64.StdEncoding.EncodeToString(file)
 
-	err := rt.client.Call("load.raw_start_verbose", []interface{}{"", xmlrpc.Base64(base64)}, nil)
+	err := rt.call(ctx, "load.raw_start_verbose", []interface{}{"", xmlrpc.Base64(base64)}, nil)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// LoadOptions are applied to a torrent when it is loaded
+type LoadOptions struct {
+	// Start the torrent after loading
+	Start bool
+	// Download directory, rTorrent default if empty
+	Directory string
+	// Priority between 0 and 3, rTorrent default if nil
+	Priority *int
+	// Values of d.custom1 to d.custom5, empty values are not set.
+	// d.custom1 is used as the label by most clients.
+	Custom [5]string
+}
+
+// Returns the post load commands for the options
+func (o LoadOptions) commands() ([]interface{}, error) {
+	commands := make([]interface{}, 0)
+	if o.Directory != "" {
+		if !strings.HasPrefix(o.Directory, "/") {
+			return nil, fmt.Errorf("%w: directory must be an absolute path", ErrInvalidArgument)
+		}
+		commands = append(commands, "d.directory.set="+quoteCommand(o.Directory))
+	}
+	if o.Priority != nil {
+		if *o.Priority < 0 || *o.Priority > 3 {
+			return nil, fmt.Errorf("%w: priority must be between 0 and 3", ErrInvalidArgument)
+		}
+		commands = append(commands, "d.priority.set="+strconv.Itoa(*o.Priority))
+	}
+	for i, value := range o.Custom {
+		if value == "" {
+			continue
+		}
+		commands = append(commands, fmt.Sprintf("d.custom%d.set=%s", i+1, quoteCommand(value)))
+	}
+	return commands, nil
+}
+
+// Quotes a value for use as an argument in a rTorrent command
+func quoteCommand(value string) string {
+	value = strings.ReplaceAll(value, `\`, `\\`)
+	value = strings.ReplaceAll(value, `"`, `\"`)
+	return `"` + value + `"`
+}
+
+// Load a torrent from raw .torrent file contents with options
+func (rt *Rtorrent) LoadRaw(file []byte, options LoadOptions) error {
+	return rt.LoadRawContext(context.Background(), file, options)
+}
+
+// Load a torrent from raw .torrent file contents with options
+func (rt *Rtorrent) LoadRawContext(ctx context.Context, file []byte, options LoadOptions) error {
+	commands, err := options.commands()
+	if err != nil {
+		return err
+	}
+
+	method := "load.raw_verbose"
+	if options.Start {
+		method = "load.raw_start_verbose"
+	}
+
+	base64 := base64.StdEncoding.EncodeToString(file)
+	args := append([]interface{}{"", xmlrpc.Base64(base64)}, commands...)
+	err = rt.call(ctx, method, args, nil)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// Load a torrent from an URI (e.g. magnet link) rTorrent can resolve itself with options
+func (rt *Rtorrent) Load(uri string, options LoadOptions) error {
+	return rt.LoadContext(context.Background(), uri, options)
+}
+
+// Load a torrent from an URI (e.g. magnet link) rTorrent can resolve itself with options
+func (rt *Rtorrent) LoadContext(ctx context.Context, uri string, options LoadOptions) error {
+	commands, err := options.commands()
+	if err != nil {
+		return err
+	}
+
+	method := "load.verbose"
+	if options.Start {
+		method = "load.start_verbose"
+	}
+
+	args := append([]interface{}{"", uri}, commands...)
+	err = rt.call(ctx, method, args, nil)
 	if err != nil {
 		return err
 	}
@@ -158,7 +404,12 @@ func (rt *Rtorrent) LoadRawStart(file []byte) error {
 
 // Stop torrent with the specified hash
 func (rt *Rtorrent) Stop(hash string) error {
-	err := rt.client.Call("d.stop", hash, nil)
+	return rt.StopContext(context.Background(), hash)
+}
+
+// Stop torrent with the specified hash
+func (rt *Rtorrent) StopContext(ctx context.Context, hash string) error {
+	err := rt.call(ctx, "d.stop", hash, nil)
 	if err != nil {
 		return err
 	}
@@ -167,7 +418,12 @@ func (rt *Rtorrent) Stop(hash string) error {
 
 // Start torrent with the specified hash
 func (rt *Rtorrent) Start(hash string) error {
-	err := rt.client.Call("d.start", hash, nil)
+	return rt.StartContext(context.Background(), hash)
+}
+
+// Start torrent with the specified hash
+func (rt *Rtorrent) StartContext(ctx context.Context, hash string) error {
+	err := rt.call(ctx, "d.start", hash, nil)
 	if err != nil {
 		return err
 	}
@@ -176,25 +432,40 @@ func (rt *Rtorrent) Start(hash string) error {
 
 // Pause torrent with the specified hash
 func (rt *Rtorrent) Pause(hash string) error {
-	err := rt.client.Call("d.pause", hash, nil)
+	return rt.PauseContext(context.Background(), hash)
+}
+
+// Pause torrent with the specified hash
+func (rt *Rtorrent) PauseContext(ctx context.Context, hash string) error {
+	err := rt.call(ctx, "d.pause", hash, nil)
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
-// Pause torrent with the specified hash
+// Resume torrent with the specified hash
 func (rt *Rtorrent) Resume(hash string) error {
-	err := rt.client.Call("d.resume", hash, nil)
+	return rt.ResumeContext(context.Background(), hash)
+}
+
+// Resume torrent with the specified hash
+func (rt *Rtorrent) ResumeContext(ctx context.Context, hash string) error {
+	err := rt.call(ctx, "d.resume", hash, nil)
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
-// Pause torrent with the specified hash
+// Check hash of torrent with the specified hash
 func (rt *Rtorrent) CheckHash(hash string) error {
-	err := rt.client.Call("d.check_hash", hash, nil)
+	return rt.CheckHashContext(context.Background(), hash)
+}
+
+// Check hash of torrent with the specified hash
+func (rt *Rtorrent) CheckHashContext(ctx context.Context, hash string) error {
+	err := rt.call(ctx, "d.check_hash", hash, nil)
 	if err != nil {
 		return err
 	}
@@ -203,30 +474,125 @@ func (rt *Rtorrent) CheckHash(hash string) error {
 
 // Erase torrent with the specified hash
 func (rt *Rtorrent) Erase(hash string) error {
-	err := rt.client.Call("d.erase", hash, nil)
+	return rt.EraseContext(context.Background(), hash)
+}
+
+// Erase torrent with the specified hash
+func (rt *Rtorrent) EraseContext(ctx context.Context, hash string) error {
+	err := rt.call(ctx, "d.erase", hash, nil)
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
+// Get torrent with the specified hash
+func (rt *Rtorrent) Torrent(hash string) (Torrent, error) {
+	return rt.TorrentContext(context.Background(), hash)
+}
+
+// Get torrent with the specified hash. The fields are fetched in a single system.multicall.
+func (rt *Rtorrent) TorrentContext(ctx context.Context, hash string) (Torrent, error) {
+	commands := MulticallCommands[Torrent]()
+	calls := make([]SystemCall, 0, len(commands))
+	for _, command := range commands {
+		calls = append(calls, SystemCall{MethodName: strings.TrimSuffix(command, "="), Params: []interface{}{hash}})
+	}
+
+	values, errs, err := rt.batch(ctx, calls)
+	if err != nil {
+		return Torrent{}, err
+	}
+	// every field fails the same way if the torrent does not exist
+	for _, err := range errs {
+		if err != nil {
+			return Torrent{}, err
+		}
+	}
+
+	torrents, err := multicallTags[Torrent]([]interface{}{values}, commands)
+	err = decodeError("system.multicall", err)
+	if err != nil {
+		return Torrent{}, err
+	}
+	return torrents[0], nil
+}
+
 // Set torrent priority
 func (rt *Rtorrent) Priority(hash string, priority int) error {
+	return rt.PriorityContext(context.Background(), hash, priority)
+}
+
+// Set torrent priority
+func (rt *Rtorrent) PriorityContext(ctx context.Context, hash string, priority int) error {
 	if priority < 0 || priority > 3 {
-		return errors.New("invalid priority")
+		return fmt.Errorf("%w: priority must be between 0 and 3", ErrInvalidArgument)
 	}
 
-	err := rt.client.Call("d.priority.set", []interface{}{hash, priority}, nil)
+	err := rt.call(ctx, "d.priority.set", []interface{}{hash, priority}, nil)
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
+// File priorities
+const (
+	FilePriorityOff    = 0
+	FilePriorityNormal = 1
+	FilePriorityHigh   = 2
+)
+
+// Set priorities of files in a torrent, priorities are mapped by file index
+func (rt *Rtorrent) FilePriorities(hash string, priorities map[int]int) error {
+	return rt.FilePrioritiesContext(context.Background(), hash, priorities)
+}
+
+// Set priorities of files in a torrent, priorities are mapped by file index.
+// The files and d.update_priorities are set in a single system.multicall.
+func (rt *Rtorrent) FilePrioritiesContext(ctx context.Context, hash string, priorities map[int]int) error {
+	indexes := make([]int, 0, len(priorities))
+	for index, priority := range priorities {
+		if index < 0 {
+			return fmt.Errorf("%w: file index must not be negative", ErrInvalidArgument)
+		}
+		if priority < FilePriorityOff || priority > FilePriorityHigh {
+			return fmt.Errorf("%w: file priority must be between 0 and 2", ErrInvalidArgument)
+		}
+		indexes = append(indexes, index)
+	}
+	sort.Ints(indexes)
+
+	calls := make([]SystemCall, 0, len(indexes)+1)
+	for _, index := range indexes {
+		calls = append(calls, SystemCall{
+			MethodName: "f.priority.set",
+			Params:     []interface{}{fmt.Sprintf("%s:f%d", hash, index), priorities[index]},
+		})
+	}
+	calls = append(calls, SystemCall{MethodName: "d.update_priorities", Params: []interface{}{hash}})
+
+	errs, err := rt.BatchContext(ctx, calls)
+	if err != nil {
+		return err
+	}
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // Set global down throttle.
 func (rt *Rtorrent) GlobalThrottleDown(kilobytes int) error {
+	return rt.GlobalThrottleDownContext(context.Background(), kilobytes)
+}
+
+// Set global down throttle.
+func (rt *Rtorrent) GlobalThrottleDownContext(ctx context.Context, kilobytes int) error {
 	kb := strconv.Itoa(kilobytes)
-	err := rt.client.Call("throttle.global_down.max_rate.set_kb", []interface{}{"", kb}, nil)
+	err := rt.call(ctx, "throttle.global_down.max_rate.set_kb", []interface{}{"", kb}, nil)
 	if err != nil {
 		return err
 	}
@@ -235,8 +601,13 @@ func (rt *Rtorrent) GlobalThrottleDown(kilobytes int) error {
 
 // Set global up throttle.
 func (rt *Rtorrent) GlobalThrottleUp(kilobytes int) error {
+	return rt.GlobalThrottleUpContext(context.Background(), kilobytes)
+}
+
+// Set global up throttle.
+func (rt *Rtorrent) GlobalThrottleUpContext(ctx context.Context, kilobytes int) error {
 	kb := strconv.Itoa(kilobytes)
-	err := rt.client.Call("throttle.global_up.max_rate.set_kb", []interface{}{"", kb}, nil)
+	err := rt.call(ctx, "throttle.global_up.max_rate.set_kb", []interface{}{"", kb}, nil)
 	if err != nil {
 		return err
 	}
@@ -245,108 +616,241 @@ func (rt *Rtorrent) GlobalThrottleUp(kilobytes int) error {
 
 // View multicall.
 func (rt *Rtorrent) DMulticall(target string, args interface{}) ([]Torrent, error) {
-	var result interface{}
-	err := rt.client.Call("d.multicall2", args, &result)
+	return rt.DMulticallContext(context.Background(), target, args)
+}
+
+// View multicall.
+func (rt *Rtorrent) DMulticallContext(ctx context.Context, target string, args interface{}) ([]Torrent, error) {
+	params, commands, err := multicallArgs(args)
 	if err != nil {
 		return nil, err
 	}
-
-	torrents := multicallTags[Torrent](result, args)
-	return torrents, nil
+	return multicall[Torrent](ctx, rt, "d.multicall2", params, commands)
 }
 
 // File multicall.
 func (rt *Rtorrent) FMulticall(args interface{}) ([]File, error) {
-	var result interface{}
-	err := rt.client.Call("f.multicall", args, &result)
+	return rt.FMulticallContext(context.Background(), args)
+}
+
+// File multicall.
+func (rt *Rtorrent) FMulticallContext(ctx context.Context, args interface{}) ([]File, error) {
+	params, commands, err := multicallArgs(args)
 	if err != nil {
 		return nil, err
 	}
-
-	files := multicallTags[File](result, args)
-	return files, nil
+	return multicall[File](ctx, rt, "f.multicall", params, commands)
 }
 
 // Peer multicall.
 func (rt *Rtorrent) PMulticall(args interface{}) ([]Peer, error) {
-	var result interface{}
-	err := rt.client.Call("p.multicall", args, &result)
+	return rt.PMulticallContext(context.Background(), args)
+}
+
+// Peer multicall.
+func (rt *Rtorrent) PMulticallContext(ctx context.Context, args interface{}) ([]Peer, error) {
+	params, commands, err := multicallArgs(args)
 	if err != nil {
 		return nil, err
 	}
-
-	peers := multicallTags[Peer](result, args)
-	return peers, nil
+	return multicall[Peer](ctx, rt, "p.multicall", params, commands)
 }
 
 // Torrent multicall.
 func (rt *Rtorrent) TMulticall(args interface{}) ([]Tracker, error) {
-	var result interface{}
-	err := rt.client.Call("t.multicall", args, &result)
+	return rt.TMulticallContext(context.Background(), args)
+}
+
+// Torrent multicall.
+func (rt *Rtorrent) TMulticallContext(ctx context.Context, args interface{}) ([]Tracker, error) {
+	params, commands, err := multicallArgs(args)
 	if err != nil {
 		return nil, err
 	}
-
-	trackers := multicallTags[Tracker](result, args)
-	return trackers, nil
+	return multicall[Tracker](ctx, rt, "t.multicall", params, commands)
 }
 
 // System multicall.
 func (rt *Rtorrent) SystemMulticall(args interface{}) (System, error) {
+	return rt.SystemMulticallContext(context.Background(), args)
+}
+
+// System multicall.
+func (rt *Rtorrent) SystemMulticallContext(ctx context.Context, args interface{}) (System, error) {
 	var result interface{}
-	err := rt.client.Call("system.multicall", args, &result)
+	err := rt.call(ctx, "system.multicall", args, &result)
 	if err != nil {
 		return System{}, err
 	}
 
-	system := systemTags(result, args)
+	system, err := systemTags(result, args)
+	err = decodeError("system.multicall", err)
+	if err != nil {
+		return System{}, err
+	}
 	return system, nil
 }
 
-// Maps XMLRPC result to a struct using fields from args with reflection
-func multicallTags[T File | Torrent | Peer | Tracker](result interface{}, args interface{}) []T {
-	items := make([]T, 0)
-	for _, outer := range result.([]interface{}) {
+// Calls multiple methods in a single system.multicall. Returns an error for each
+// call, nil if the call succeeded.
+func (rt *Rtorrent) Batch(calls []SystemCall) ([]error, error) {
+	return rt.BatchContext(context.Background(), calls)
+}
+
+// Calls multiple methods in a single system.multicall. Returns an error for each
+// call, nil if the call succeeded.
+func (rt *Rtorrent) BatchContext(ctx context.Context, calls []SystemCall) ([]error, error) {
+	_, errs, err := rt.batch(ctx, calls)
+	return errs, err
+}
+
+// Calls multiple methods in a single system.multicall. Returns the value and an error
+// for each call, the value is nil if the call failed.
+func (rt *Rtorrent) batch(ctx context.Context, calls []SystemCall) ([]interface{}, []error, error) {
+	args := make([]interface{}, 0, len(calls))
+	for _, call := range calls {
+		args = append(args, call)
+	}
+
+	var result []interface{}
+	err := rt.call(ctx, "system.multicall", []interface{}{args}, &result)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(result) != len(calls) {
+		return nil, nil, &DecodeError{Method: "system.multicall", Err: fmt.Errorf("expected %d results, got %d", len(calls), len(result))}
+	}
+
+	values := make([]interface{}, len(calls))
+	errs := make([]error, len(calls))
+	for i, r := range result {
+		// successful calls are wrapped in a single element array, failed calls are fault structs
+		switch v := r.(type) {
+		case []interface{}:
+			if len(v) > 0 {
+				values[i] = v[0]
+			}
+		case map[string]interface{}:
+			code, _ := toInt64(v["faultCode"])
+			message, _ := v["faultString"].(string)
+			errs[i] = &FaultError{Method: calls[i].MethodName, Code: int(code), String: message}
+		default:
+			errs[i] = &DecodeError{Method: calls[i].MethodName, Err: fmt.Errorf("unexpected result %T", r)}
+		}
+	}
+	return values, errs, nil
+}
+
+// Splits hand written multicall args into the two leading params and the commands
+func multicallArgs(args interface{}) ([]interface{}, []string, error) {
+	a, ok := args.([]interface{})
+	if !ok || len(a) < 2 {
+		return nil, nil, fmt.Errorf("%w: unexpected multicall args %T", ErrInvalidArgument, args)
+	}
+	commands := make([]string, 0, len(a)-2)
+	for _, arg := range a[2:] {
+		command, ok := arg.(string)
+		if !ok {
+			return nil, nil, fmt.Errorf("%w: unexpected multicall command %T", ErrInvalidArgument, arg)
+		}
+		commands = append(commands, command)
+	}
+	return a[:2], commands, nil
+}
+
+// Maps XMLRPC result to a struct using the rt tags matching commands with reflection.
+// Values which can not be decoded are reported as FieldErrors.
+func multicallTags[T any](result interface{}, commands []string) ([]T, error) {
+	t := reflect.TypeOf(*new(T))
+	if t == nil || t.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("cant decode multicall into %v", t)
+	}
+
+	rows, ok := result.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("expected array, got %T", result)
+	}
+
+	fields := tagFields(t)
+	items := make([]T, 0, len(rows))
+	var errs FieldErrors
+	for row, outer := range rows {
 		item := new(T)
-		for idx := 2; idx < len(args.([]interface{})); idx++ {
-			ref := outer.([]interface{})[idx-2]
-			fname := args.([]interface{})[idx].(string)
-			vo := reflect.ValueOf(item)
-			el := vo.Elem()
-			for i := 0; i < el.NumField(); i++ {
-				field := el.Type().Field(i)
-				if fname == field.Tag.Get("rt") {
-					if ref == nil {
-						continue
-					}
-					el.Field(i).Set(reflect.ValueOf(ref))
+		el := reflect.ValueOf(item).Elem()
+
+		values, ok := outer.([]interface{})
+		if !ok {
+			errs = append(errs, FieldError{Row: row, Err: fmt.Errorf("expected array, got %T", outer)})
+			items = append(items, *item)
+			continue
+		}
+
+		for idx, command := range commands {
+			if idx >= len(values) {
+				errs = append(errs, FieldError{Row: row, Command: command, Err: errors.New("missing value")})
+				continue
+			}
+			for _, i := range fields[command] {
+				err := decodeValue(el.Field(i), values[idx])
+				if err != nil {
+					errs = append(errs, FieldError{Row: row, Command: command, Err: err})
 				}
 			}
-
 		}
 		items = append(items, *item)
 	}
-	return items
+
+	if len(errs) > 0 {
+		return items, errs
+	}
+	return items, nil
 }
 
-func systemTags(result interface{}, args interface{}) System {
+// Maps XMLRPC system.multicall result to System using method names from args with reflection.
+// Values which can not be decoded are reported as FieldErrors.
+func systemTags(result interface{}, args interface{}) (System, error) {
 	system := &System{}
-	r := result.([]interface{})
-	a := args.([]interface{})
-	for idx := 0; idx < len(r); idx++ {
-		ref := r[idx].([]interface{})[0]
-		fname := a[0].([]interface{})[idx].(SystemCall).MethodName
-		vo := reflect.ValueOf(system)
-		el := vo.Elem()
-		for i := 0; i < el.NumField(); i++ {
-			field := el.Type().Field(i)
-			if fname == field.Tag.Get("rt") {
-				if ref == nil {
-					continue
-				}
-				el.Field(i).Set(reflect.ValueOf(ref))
+
+	a, ok := args.([]interface{})
+	if !ok || len(a) == 0 {
+		return *system, fmt.Errorf("unexpected system multicall args %T", args)
+	}
+	calls, ok := a[0].([]interface{})
+	if !ok {
+		return *system, fmt.Errorf("unexpected system multicall calls %T", a[0])
+	}
+
+	r, ok := result.([]interface{})
+	if !ok {
+		return *system, fmt.Errorf("expected array, got %T", result)
+	}
+
+	fields := tagFields(reflect.TypeOf(*system))
+	el := reflect.ValueOf(system).Elem()
+	var errs FieldErrors
+	for idx := 0; idx < len(r) && idx < len(calls); idx++ {
+		call, ok := calls[idx].(SystemCall)
+		if !ok {
+			return *system, fmt.Errorf("unexpected system multicall call %T", calls[idx])
+		}
+
+		// successful calls are wrapped in a single element array, failed calls are fault structs
+		values, ok := r[idx].([]interface{})
+		if !ok || len(values) != 1 {
+			errs = append(errs, FieldError{Command: call.MethodName, Err: fmt.Errorf("unexpected value %v", r[idx])})
+			continue
+		}
+
+		for _, i := range fields[call.MethodName] {
+			err := decodeValue(el.Field(i), values[0])
+			if err != nil {
+				errs = append(errs, FieldError{Command: call.MethodName, Err: err})
 			}
 		}
 	}
-	return *system
+
+	if len(errs) > 0 {
+		return *system, errs
+	}
+	return *system, nil
 }
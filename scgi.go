@@ -0,0 +1,175 @@
+package kahva
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// scgiTransport is a http.RoundTripper which talks to the rTorrent
+// SCGI socket directly instead of going through a web server.
+type scgiTransport struct {
+	network string
+	address string
+	dialer  net.Dialer
+}
+
+// Creates a new SCGI transport from an URL with scheme scgi (TCP) or scgi+unix (UNIX socket)
+func newSCGITransport(u *url.URL) (*scgiTransport, error) {
+	switch u.Scheme {
+	case "scgi":
+		if u.Host == "" {
+			return nil, fmt.Errorf("scgi url %q is missing host", u.String())
+		}
+		return &scgiTransport{network: "tcp", address: u.Host}, nil
+	case "scgi+unix":
+		if u.Path == "" {
+			return nil, fmt.Errorf("scgi url %q is missing socket path", u.String())
+		}
+		return &scgiTransport{network: "unix", address: u.Path}, nil
+	}
+	return nil, fmt.Errorf("unsupported scgi scheme %q", u.Scheme)
+}
+
+// Returns true if the URL should be served by the SCGI transport
+func isSCGI(u *url.URL) bool {
+	return u.Scheme == "scgi" || u.Scheme == "scgi+unix"
+}
+
+func (t *scgiTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var body []byte
+	if req.Body != nil {
+		b, err := io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		body = b
+	}
+
+	conn, err := t.dialer.DialContext(req.Context(), t.network, t.address)
+	if err != nil {
+		return nil, err
+	}
+
+	// abort the exchange if the request context is cancelled
+	stop := context.AfterFunc(req.Context(), func() {
+		conn.Close()
+	})
+
+	_, err = conn.Write(scgiRequest(req, body))
+	if err != nil {
+		stop()
+		conn.Close()
+		return nil, err
+	}
+
+	res, err := scgiResponse(req, conn)
+	if err != nil {
+		stop()
+		conn.Close()
+		return nil, err
+	}
+	res.Body = &scgiBody{Reader: res.Body, conn: conn, stop: stop}
+	return res, nil
+}
+
+// Encodes the request headers as a netstring followed by the request body.
+// CONTENT_LENGTH must be the first header and SCGI must be set to 1.
+func scgiRequest(req *http.Request, body []byte) []byte {
+	uri := req.URL.RequestURI()
+	if req.URL.Scheme == "scgi+unix" {
+		uri = "/"
+	}
+
+	headers := [][2]string{
+		{"CONTENT_LENGTH", strconv.Itoa(len(body))},
+		{"SCGI", "1"},
+		{"REQUEST_METHOD", req.Method},
+		{"REQUEST_URI", uri},
+		{"SERVER_PROTOCOL", "HTTP/1.1"},
+	}
+	if ct := req.Header.Get("Content-Type"); ct != "" {
+		headers = append(headers, [2]string{"CONTENT_TYPE", ct})
+	}
+
+	var h bytes.Buffer
+	for _, kv := range headers {
+		h.WriteString(kv[0])
+		h.WriteByte(0)
+		h.WriteString(kv[1])
+		h.WriteByte(0)
+	}
+
+	var b bytes.Buffer
+	b.WriteString(strconv.Itoa(h.Len()))
+	b.WriteByte(':')
+	b.Write(h.Bytes())
+	b.WriteByte(',')
+	b.Write(body)
+	return b.Bytes()
+}
+
+// Reads a CGI style response (headers with an optional Status header, blank line, body)
+func scgiResponse(req *http.Request, conn net.Conn) (*http.Response, error) {
+	reader := bufio.NewReader(conn)
+	header, err := textproto.NewReader(reader).ReadMIMEHeader()
+	if err != nil {
+		return nil, fmt.Errorf("cant read scgi response header: %w", err)
+	}
+
+	code := http.StatusOK
+	status := header.Get("Status")
+	if status != "" {
+		code, err = strconv.Atoi(strings.SplitN(status, " ", 2)[0])
+		if err != nil {
+			return nil, fmt.Errorf("invalid scgi status %q", status)
+		}
+		header.Del("Status")
+	} else {
+		status = "200 OK"
+	}
+
+	res := &http.Response{
+		Status:        status,
+		StatusCode:    code,
+		Proto:         "HTTP/1.0",
+		ProtoMajor:    1,
+		ProtoMinor:    0,
+		Header:        http.Header(header),
+		Body:          io.NopCloser(reader),
+		ContentLength: -1,
+		Close:         true,
+		Request:       req,
+	}
+
+	if cl := header.Get("Content-Length"); cl != "" {
+		n, err := strconv.ParseInt(cl, 10, 64)
+		if err == nil {
+			res.ContentLength = n
+			res.Body = io.NopCloser(io.LimitReader(reader, n))
+		}
+	}
+
+	return res, nil
+}
+
+// scgiBody closes the underlying connection when the response body is closed
+type scgiBody struct {
+	io.Reader
+	conn net.Conn
+	stop func() bool
+}
+
+func (b *scgiBody) Close() error {
+	b.stop()
+	return b.conn.Close()
+}
@@ -0,0 +1,103 @@
+package kahva
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// Returns the labels the user of a context is restricted to, nil if the user
+// can access every torrent or authentication is disabled
+func scopeFromContext(ctx context.Context) []string {
+	user, ok := UserFromContext(ctx)
+	if !ok {
+		return nil
+	}
+	return user.Labels
+}
+
+// Returns true if the torrent has one of the labels of the scope. Every torrent is in an empty scope.
+func inScope(custom1 string, scope []string, delimiter string) bool {
+	if len(scope) == 0 {
+		return true
+	}
+	for _, label := range scope {
+		if hasLabel(custom1, label, delimiter) {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the torrents in the scope
+func filterScope(torrents []Torrent, scope []string, delimiter string) []Torrent {
+	if len(scope) == 0 {
+		return torrents
+	}
+	filtered := make([]Torrent, 0, len(torrents))
+	for _, t := range torrents {
+		if inScope(t.Custom1, scope, delimiter) {
+			filtered = append(filtered, t)
+		}
+	}
+	return filtered
+}
+
+// Adds the first label of the scope to custom1 of a torrent that is not in the scope
+func stampScope(custom1 string, scope []string, delimiter string) (string, error) {
+	if inScope(custom1, scope, delimiter) {
+		return custom1, nil
+	}
+	return formatLabels(append(parseLabels(custom1, delimiter), scope[0]), delimiter)
+}
+
+// Returns an error if the user of the context can not access the torrent
+func checkScope(ctx context.Context, rt *Rtorrent, hash string) error {
+	scope := scopeFromContext(ctx)
+	if len(scope) == 0 {
+		return nil
+	}
+	var custom1 string
+	err := rt.call(ctx, "d.custom1", hash, &custom1)
+	if err != nil {
+		return err
+	}
+	if !inScope(custom1, scope, rt.labelDelimiter) {
+		return fmt.Errorf("%w: torrent %s is outside your labels", ErrForbidden, strings.ToUpper(hash))
+	}
+	return nil
+}
+
+// Returns an error if a d.custom1.set call would move a torrent out of the
+// scope of the user of the context
+func checkLabelCalls(ctx context.Context, calls []SystemCall, delimiter string) error {
+	scope := scopeFromContext(ctx)
+	for _, call := range calls {
+		params, ok := call.Params.([]interface{})
+		if call.MethodName != "d.custom1.set" || !ok || len(params) != 2 {
+			continue
+		}
+		value, _ := params[1].(string)
+		if !inScope(value, scope, delimiter) {
+			return fmt.Errorf("%w: torrents must keep one of the labels %s", ErrForbidden, strings.Join(scope, ", "))
+		}
+	}
+	return nil
+}
+
+// Rejects requests for a torrent hash route variable outside the labels of the user
+func ScopeMiddleware(rt *Rtorrent) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			err := checkScope(r.Context(), rt, mux.Vars(r)["hash"])
+			if err != nil {
+				respondError(err, w)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,112 @@
+package kahva
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// rTorrent lists DHT as a pseudo tracker with this URL
+const dhtTracker = "dht://"
+
+// Returns the calls performing a tracker action on a torrent. trackers are the current
+// trackers of the torrent and are used to validate indexes and pick the group of added trackers.
+// rTorrent can not remove trackers, remove disables them and they stay in the tracker list.
+func trackerCalls(hash string, req TrackerRequest, trackers []Tracker) ([]SystemCall, error) {
+	switch req.Action {
+	case "enable", "disable", "remove":
+		if len(req.Indexes) == 0 {
+			return nil, fmt.Errorf("%w: indexes are required", ErrInvalidArgument)
+		}
+		enabled := 0
+		if req.Action == "enable" {
+			enabled = 1
+		}
+
+		calls := make([]SystemCall, 0, len(req.Indexes))
+		for _, index := range req.Indexes {
+			if index < 0 || index >= len(trackers) {
+				return nil, fmt.Errorf("%w: tracker index %d out of range, torrent has %d trackers", ErrInvalidArgument, index, len(trackers))
+			}
+			calls = append(calls, SystemCall{
+				MethodName: "t.is_enabled.set",
+				Params:     []interface{}{fmt.Sprintf("%s:t%d", hash, index), enabled},
+			})
+		}
+		return calls, nil
+	case "add":
+		err := validateTrackerURL(req.URL)
+		if err != nil {
+			return nil, err
+		}
+		for _, t := range trackers {
+			if t.URL == req.URL {
+				return nil, fmt.Errorf("%w: torrent already has tracker %q", ErrInvalidArgument, req.URL)
+			}
+		}
+
+		// new trackers get their own group after the existing ones by default
+		group := int64(0)
+		for _, t := range trackers {
+			if t.URL != dhtTracker && t.Group >= group {
+				group = t.Group + 1
+			}
+		}
+		if req.Group != nil {
+			if *req.Group < 0 {
+				return nil, fmt.Errorf("%w: group must not be negative", ErrInvalidArgument)
+			}
+			group = int64(*req.Group)
+		}
+		return []SystemCall{{MethodName: "d.tracker.insert", Params: []interface{}{hash, group, req.URL}}}, nil
+	case "announce":
+		return []SystemCall{{MethodName: "d.tracker_announce", Params: []interface{}{hash}}}, nil
+	}
+	return nil, fmt.Errorf("%w: unknown tracker action %q", ErrInvalidArgument, req.Action)
+}
+
+// Announce URLs must be absolute http(s) or udp URLs
+func validateTrackerURL(uri string) error {
+	u, err := url.Parse(uri)
+	if err != nil || u.Host == "" {
+		return fmt.Errorf("%w: invalid tracker url %q", ErrInvalidArgument, uri)
+	}
+	switch u.Scheme {
+	case "http", "https", "udp":
+		return nil
+	}
+	return fmt.Errorf("%w: tracker url must be http, https or udp", ErrInvalidArgument)
+}
+
+// Returns the announce URL changes and the calls replacing the prefix from with to on a torrent.
+// rTorrent can not change or remove trackers, so the new URL is inserted into the group
+// of the old tracker and the old tracker is disabled.
+func replaceCalls(hash string, trackers []Tracker, from string, to string) ([]TrackerChange, []SystemCall) {
+	existing := make(map[string]bool)
+	for _, t := range trackers {
+		existing[t.URL] = true
+	}
+
+	changes := make([]TrackerChange, 0)
+	calls := make([]SystemCall, 0)
+	for index, t := range trackers {
+		if t.URL == dhtTracker || !strings.HasPrefix(t.URL, from) || t.IsEnabled == 0 {
+			continue
+		}
+		replaced := to + strings.TrimPrefix(t.URL, from)
+		if replaced == t.URL {
+			continue
+		}
+		changes = append(changes, TrackerChange{Index: index, From: t.URL, To: replaced})
+
+		if !existing[replaced] {
+			existing[replaced] = true
+			calls = append(calls, SystemCall{MethodName: "d.tracker.insert", Params: []interface{}{hash, t.Group, replaced}})
+		}
+		calls = append(calls, SystemCall{
+			MethodName: "t.is_enabled.set",
+			Params:     []interface{}{fmt.Sprintf("%s:t%d", hash, index), 0},
+		})
+	}
+	return changes, calls
+}
@@ -0,0 +1,248 @@
+package kahva
+
+import (
+	"fmt"
+	"net/url"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ViewQuery contains filtering, sorting and pagination options for a view
+type ViewQuery struct {
+	// Case insensitive substring of the torrent name
+	Name string
+	// Regular expression matched against the torrent name
+	NameRegex *regexp.Regexp
+	// One of started, stopped, paused or hashing
+	State string
+	// Label the torrent has, empty matches torrents without labels
+	Label *string
+	// Delimiter between multiple labels in custom1
+	LabelDelimiter string
+	// Labels of which the torrent must have at least one, empty matches every torrent
+	Scope []string
+	// Case insensitive substring of the tracker message
+	Message string
+	Active  *bool
+	// Completed bytes equal size bytes
+	Complete *bool
+
+	// JSON field name to sort on
+	Sort       string
+	Descending bool
+
+	Offset int
+	// Zero means no limit
+	Limit int
+}
+
+var viewStates = map[string]bool{
+	"started": true,
+	"stopped": true,
+	"paused":  true,
+	"hashing": true,
+}
+
+// Parses view query parameters. Invalid values are rejected with ErrInvalidArgument.
+func ParseViewQuery(values url.Values) (ViewQuery, error) {
+	q := ViewQuery{
+		Name:    values.Get("name"),
+		State:   values.Get("state"),
+		Message: values.Get("message"),
+	}
+
+	if values.Has("name_regex") {
+		rx, err := regexp.Compile(values.Get("name_regex"))
+		if err != nil {
+			return q, fmt.Errorf("%w: name_regex: %s", ErrInvalidArgument, err)
+		}
+		q.NameRegex = rx
+	}
+
+	if q.State != "" && !viewStates[q.State] {
+		return q, fmt.Errorf("%w: state must be started, stopped, paused or hashing", ErrInvalidArgument)
+	}
+
+	if values.Has("label") {
+		label := values.Get("label")
+		q.Label = &label
+	}
+
+	for key, dst := range map[string]**bool{"active": &q.Active, "complete": &q.Complete} {
+		if !values.Has(key) {
+			continue
+		}
+		b, err := queryBool(values, key)
+		if err != nil {
+			return q, err
+		}
+		*dst = &b
+	}
+
+	if values.Has("sort") {
+		q.Sort = values.Get("sort")
+		if strings.HasPrefix(q.Sort, "-") {
+			q.Sort = q.Sort[1:]
+			q.Descending = true
+		}
+		if _, err := fieldCommands[Torrent]([]string{q.Sort}); err != nil {
+			return q, fmt.Errorf("%w: cant sort on unknown field %q", ErrInvalidArgument, q.Sort)
+		}
+	}
+
+	for key, dst := range map[string]*int{"offset": &q.Offset, "limit": &q.Limit} {
+		if !values.Has(key) {
+			continue
+		}
+		n, err := strconv.Atoi(values.Get(key))
+		if err != nil || n < 0 {
+			return q, fmt.Errorf("%w: %s must be a positive integer", ErrInvalidArgument, key)
+		}
+		*dst = n
+	}
+
+	return q, nil
+}
+
+// Parses an optional boolean query parameter, false if it is not set
+func queryBool(values url.Values, key string) (bool, error) {
+	if !values.Has(key) {
+		return false, nil
+	}
+	b, err := strconv.ParseBool(values.Get(key))
+	if err != nil {
+		return false, fmt.Errorf("%w: %s must be true or false", ErrInvalidArgument, key)
+	}
+	return b, nil
+}
+
+// Returns the JSON field names the query needs to filter and sort torrents
+func (q ViewQuery) Fields() []string {
+	fields := make([]string, 0)
+	if q.Name != "" || q.NameRegex != nil {
+		fields = append(fields, "name")
+	}
+	if q.State != "" {
+		fields = append(fields, "state", "is_active", "is_hashing")
+	}
+	if q.Label != nil || len(q.Scope) > 0 {
+		fields = append(fields, "custom1")
+	}
+	if q.Message != "" {
+		fields = append(fields, "message")
+	}
+	if q.Active != nil {
+		fields = append(fields, "is_active")
+	}
+	if q.Complete != nil {
+		fields = append(fields, "size_bytes", "completed_bytes")
+	}
+	if q.Sort != "" {
+		fields = append(fields, q.Sort)
+	}
+	return fields
+}
+
+// Returns true if the torrent matches all filters of the query
+func (q ViewQuery) Match(t Torrent) bool {
+	if q.Name != "" && !strings.Contains(strings.ToLower(t.Name), strings.ToLower(q.Name)) {
+		return false
+	}
+	if q.NameRegex != nil && !q.NameRegex.MatchString(t.Name) {
+		return false
+	}
+	if q.Label != nil && !hasLabel(t.Custom1, *q.Label, q.LabelDelimiter) {
+		return false
+	}
+	if !inScope(t.Custom1, q.Scope, q.LabelDelimiter) {
+		return false
+	}
+	if q.Message != "" && !strings.Contains(strings.ToLower(t.Message), strings.ToLower(q.Message)) {
+		return false
+	}
+	if q.Active != nil && (t.IsActive == 1) != *q.Active {
+		return false
+	}
+	if q.Complete != nil && (t.SizeBytes > 0 && t.CompletedBytes >= t.SizeBytes) != *q.Complete {
+		return false
+	}
+
+	switch q.State {
+	case "started":
+		return t.State == 1 && t.IsActive == 1
+	case "stopped":
+		return t.State == 0
+	case "paused":
+		return t.State == 1 && t.IsActive == 0
+	case "hashing":
+		return t.IsHashing != 0
+	}
+	return true
+}
+
+// Filters, sorts and paginates torrents. Returns the page and the number of torrents matching the filters.
+func (q ViewQuery) Apply(torrents []Torrent) ([]Torrent, int) {
+	matched := make([]Torrent, 0, len(torrents))
+	for _, t := range torrents {
+		if q.Match(t) {
+			matched = append(matched, t)
+		}
+	}
+	total := len(matched)
+
+	if q.Sort != "" {
+		sortByField(matched, q.Sort, q.Descending)
+	}
+
+	if q.Offset >= len(matched) {
+		return matched[:0], total
+	}
+	matched = matched[q.Offset:]
+	if q.Limit > 0 && q.Limit < len(matched) {
+		matched = matched[:q.Limit]
+	}
+	return matched, total
+}
+
+// Sorts items in place by the struct field with the given JSON name, ties keep their order
+func sortByField[T any](items []T, name string, descending bool) {
+	t := reflect.TypeOf(*new(T))
+	idx := -1
+	for i := 0; i < t.NumField(); i++ {
+		if jsonName(t.Field(i)) == name {
+			idx = i
+			break
+		}
+	}
+	if idx < 0 {
+		return
+	}
+
+	less := func(a, b reflect.Value) bool {
+		switch a.Kind() {
+		case reflect.String:
+			return strings.ToLower(a.String()) < strings.ToLower(b.String())
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			return a.Int() < b.Int()
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			return a.Uint() < b.Uint()
+		case reflect.Float32, reflect.Float64:
+			return a.Float() < b.Float()
+		case reflect.Bool:
+			return !a.Bool() && b.Bool()
+		}
+		return false
+	}
+
+	sort.SliceStable(items, func(i, j int) bool {
+		a := reflect.ValueOf(items[i]).Field(idx)
+		b := reflect.ValueOf(items[j]).Field(idx)
+		if descending {
+			return less(b, a)
+		}
+		return less(a, b)
+	})
+}